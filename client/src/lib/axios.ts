@@ -1,4 +1,4 @@
-import axios from "axios";
+import axios, { AxiosError, InternalAxiosRequestConfig } from "axios";
 
 const token = localStorage.getItem("token") || "";
 
@@ -11,12 +11,61 @@ const api = axios.create({
 
 export default api;
 
-export const setAuthToken = (newToken: string) => {
+export const setAuthToken = (newToken: string, refreshToken?: string) => {
   localStorage.setItem("token", newToken);
+  if (refreshToken) {
+    localStorage.setItem("refreshToken", refreshToken);
+  }
   api.defaults.headers.Authorization = `Bearer ${newToken}`;
 };
 
 export const removeAuthToken = () => {
   localStorage.removeItem("token");
+  localStorage.removeItem("refreshToken");
   delete api.defaults.headers.Authorization;
 };
+
+interface RefreshResponse {
+  jwt: string;
+  refreshToken: string;
+}
+
+// Concurrent requests that hit an expired token share one refresh, since
+// each refresh token can only be used once
+let refreshing: Promise<string> | null = null;
+
+const refreshAccessToken = () => {
+  if (!refreshing) {
+    const refreshToken = localStorage.getItem("refreshToken");
+    refreshing = (
+      refreshToken
+        ? axios
+            .post<RefreshResponse>("/api/token/refresh", { refreshToken })
+            .then(({ data }) => {
+              setAuthToken(data.jwt, data.refreshToken);
+              return data.jwt;
+            })
+        : Promise.reject(new Error("No refresh token"))
+    ).finally(() => {
+      refreshing = null;
+    });
+  }
+  return refreshing;
+};
+
+api.interceptors.response.use(undefined, async (error: AxiosError<{ code?: string }>) => {
+  const request = error.config as (InternalAxiosRequestConfig & { retried?: boolean }) | undefined;
+
+  if (request && !request.retried && error.response?.data?.code === "token_expired") {
+    request.retried = true;
+    try {
+      const newToken = await refreshAccessToken();
+      request.headers.Authorization = `Bearer ${newToken}`;
+      return api(request);
+    } catch {
+      removeAuthToken();
+    }
+  }
+
+  return Promise.reject(error);
+});
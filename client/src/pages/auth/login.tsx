@@ -1,5 +1,6 @@
 import { useEffect, useState } from "react";
 import { useNavigate, Link } from "react-router";
+import { AxiosError } from "axios";
 import { useAuth } from "../../store/auth";
 import { FormInput } from "../../components/form-input";
 import BackgroundGradients from "../../components/background-gradients";
@@ -7,8 +8,10 @@ import BackgroundGradients from "../../components/background-gradients";
 export function LoginPage() {
   const [username, setUsername] = useState("");
   const [password, setPassword] = useState("");
+  const [code, setCode] = useState("");
+  const [mfaToken, setMfaToken] = useState<string | null>(null);
   const [errors, setErrors] = useState<{ [key: string]: string }>({});
-  const { login, user } = useAuth();
+  const { login, loginWithCode, user } = useAuth();
   const [loading, setLoading] = useState(false);
   const navigate = useNavigate();
 
@@ -20,6 +23,11 @@ export function LoginPage() {
 
   const validateForm = () => {
     const newErrors: { [key: string]: string } = {};
+    if (mfaToken) {
+      if (!code) newErrors.code = "Code is required";
+      setErrors(newErrors);
+      return Object.keys(newErrors).length === 0;
+    }
     if (!username) newErrors.username = "Username is required";
     if (!password) newErrors.password = "Password is required";
     setErrors(newErrors);
@@ -32,12 +40,33 @@ export function LoginPage() {
 
     setLoading(true);
     try {
-      await login(username, password);
+      if (mfaToken) {
+        await loginWithCode(mfaToken, code);
+      } else {
+        const data = await login(username, password);
+        if (data.mfaRequired && data.mfaToken) {
+          setMfaToken(data.mfaToken);
+          setErrors({});
+          return;
+        }
+      }
       setErrors({});
       navigate("/");
     } catch (err) {
       console.error(err);
-      setErrors({ form: "Invalid username or password" });
+      const e = err as AxiosError<{ error?: string; code?: string }>;
+      if (mfaToken && e.response?.data?.code === "invalid_code") {
+        setErrors({ form: "Invalid code" });
+      } else if (mfaToken && e.response?.data?.code === "mfa_locked") {
+        setErrors({ form: e.response.data.error || "Too many wrong codes, try again later" });
+      } else if (mfaToken) {
+        // The challenge expired or ran out of attempts, start over
+        setMfaToken(null);
+        setCode("");
+        setErrors({ form: e.response?.data?.error || "Login expired, try again" });
+      } else {
+        setErrors({ form: "Invalid username or password" });
+      }
     } finally {
       setLoading(false);
     }
@@ -57,30 +86,45 @@ export function LoginPage() {
               {errors.form}
             </div>
           )}
-          <FormInput
-            label="Username"
-            type="text"
-            id="username"
-            value={username}
-            onChange={(e) => setUsername(e.target.value)}
-            placeholder="Enter your username"
-            error={errors.username}
-          />
-          <FormInput
-            label="Password"
-            type="password"
-            id="password"
-            value={password}
-            onChange={(e) => setPassword(e.target.value)}
-            placeholder="Enter your password"
-            error={errors.password}
-          />
+          {mfaToken ? (
+            <FormInput
+              label="Authentication code"
+              type="text"
+              id="code"
+              autoComplete="one-time-code"
+              value={code}
+              onChange={(e) => setCode(e.target.value)}
+              placeholder="Enter the code from your app or a recovery code"
+              error={errors.code}
+            />
+          ) : (
+            <>
+              <FormInput
+                label="Username"
+                type="text"
+                id="username"
+                value={username}
+                onChange={(e) => setUsername(e.target.value)}
+                placeholder="Enter your username"
+                error={errors.username}
+              />
+              <FormInput
+                label="Password"
+                type="password"
+                id="password"
+                value={password}
+                onChange={(e) => setPassword(e.target.value)}
+                placeholder="Enter your password"
+                error={errors.password}
+              />
+            </>
+          )}
           <button
             type="submit"
             disabled={loading}
             className="w-full rounded-md cursor-pointer bg-primary p-2 text-white transition-colors hover:bg-primary/90 focus:outline-none focus:ring-2 focus:ring-primary focus:ring-offset-2 focus:ring-offset-zinc-800 disabled:cursor-not-allowed disabled:opacity-80"
           >
-            {loading ? "Logging in..." : "Login"}
+            {loading ? "Logging in..." : mfaToken ? "Verify" : "Login"}
           </button>
         </form>
         <p className="mt-4 text-center text-zinc-400">
@@ -18,7 +18,7 @@ export const WebSocketProvider = ({ children }: WebSocketProviderProps) => {
       return;
     }
 
-    initWebSocket(roomId, user.username);
+    initWebSocket(roomId);
   }, [roomId, user?.username, initWebSocket]);
 
   // Only initialize WebSocket connection once when component mounts
@@ -27,7 +27,7 @@ export const WebSocketProvider = ({ children }: WebSocketProviderProps) => {
 
     return () => {
       if (user?.username) {
-        initWebSocket(null, user.username);
+        initWebSocket(null);
       }
     };
   }, [initializeWebSocket, user?.username, initWebSocket]);
@@ -7,6 +7,9 @@ import { User } from "../types/types";
 
 interface loginResponse {
   jwt?: string;
+  refreshToken?: string;
+  mfaRequired?: boolean;
+  mfaToken?: string;
   message?: string;
   error?: string;
 }
@@ -32,11 +35,34 @@ export const useAuth = () => {
 
       const data: loginResponse = response.data;
 
+      // The caller asks for a code and finishes with loginWithCode
+      if (data.mfaRequired) {
+        return data;
+      }
+
       if (!data.jwt) {
         throw new Error("No JWT token found in response");
       }
 
-      setAuthToken(data.jwt);
+      setAuthToken(data.jwt, data.refreshToken);
+      await refreshUser();
+      return data;
+    } catch (error) {
+      console.error(error);
+      throw error;
+    }
+  };
+
+  const loginWithCode = async (mfaToken: string, code: string) => {
+    try {
+      const response = await api.post("/api/login/2fa", { mfaToken, code });
+      const data: loginResponse = response.data;
+
+      if (!data.jwt) {
+        throw new Error("No JWT token found in response");
+      }
+
+      setAuthToken(data.jwt, data.refreshToken);
       await refreshUser();
       return data;
     } catch (error) {
@@ -62,7 +88,12 @@ export const useAuth = () => {
     }
   };
 
-  const logout = () => {
+  const logout = async () => {
+    try {
+      await api.post("/api/logout");
+    } catch (error) {
+      console.error(error);
+    }
     removeAuthToken();
     setUser(null);
     navigate("/login");
@@ -98,5 +129,5 @@ export const useAuth = () => {
     }
   }, [setUser, setIsLoading]);
 
-  return { user, isLoading, login, register, logout, refreshUser };
+  return { user, isLoading, login, loginWithCode, register, logout, refreshUser };
 };
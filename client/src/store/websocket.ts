@@ -1,5 +1,6 @@
 import { atom, useAtom } from "jotai";
 import { useCallback, useEffect, useRef } from "react";
+import api from "../lib/axios";
 
 // Types
 type WebSocketMessage = {
@@ -16,6 +17,21 @@ type WebSocketMessage = {
 const RECONNECT_DELAY = 3000;
 const MAX_RECONNECT_ATTEMPTS = 3;
 
+interface TicketResponse {
+  ticket: string;
+  expiresAt: string;
+}
+
+// Every connect attempt redeems a fresh single-use ticket. Requesting it goes
+// through the axios instance, so an expired access token is refreshed first
+// instead of the upgrade failing with 401.
+const fetchTicket = async (roomId: string) => {
+  const { data } = await api.post<TicketResponse>(
+    `/api/room/${encodeURIComponent(roomId)}/ws-ticket`
+  );
+  return data.ticket;
+};
+
 // Atoms
 export const wsConnectionAtom = atom<WebSocket | null>(null);
 export const wsConnectedAtom = atom<boolean>(false);
@@ -25,7 +41,7 @@ export const wsErrorAtom = atom<string | null>(null);
 // Derived atom for managing the connection
 export const wsManagerAtom = atom(
   (get) => get(wsConnectionAtom),
-  (get, set, roomId: string | null, username: string) => {
+  (get, set, roomId: string | null) => {
     if (!roomId) {
       const existingWs = get(wsConnectionAtom);
       if (existingWs) {
@@ -47,12 +63,44 @@ export const wsManagerAtom = atom(
 
     let reconnectAttempts = 0;
     let reconnectTimeout: number;
+    let currentWs: WebSocket | null = null;
+    let cancelled = false;
+
+    const scheduleReconnect = () => {
+      if (reconnectAttempts < MAX_RECONNECT_ATTEMPTS) {
+        reconnectAttempts++;
+        set(
+          wsErrorAtom,
+          `Connection lost. Attempt ${reconnectAttempts}/${MAX_RECONNECT_ATTEMPTS} to reconnect...`
+        );
+
+        reconnectTimeout = window.setTimeout(connectWebSocket, RECONNECT_DELAY);
+      } else {
+        set(
+          wsErrorAtom,
+          "Connection failed after multiple attempts. Please try again later."
+        );
+      }
+    };
 
-    const connectWebSocket = () => {
-      // Remove any quotes from username and properly encode both parameters
-      const cleanUsername = encodeURIComponent(username.replace(/['"]/g, ""));
+    const connectWebSocket = async () => {
       const cleanRoomId = encodeURIComponent(roomId);
 
+      let ticket: string;
+      try {
+        ticket = await fetchTicket(roomId);
+      } catch (error) {
+        console.error(`[WS Error] Could not get a ticket for room ${roomId}:`, error);
+        if (!cancelled) {
+          scheduleReconnect();
+        }
+        return;
+      }
+
+      if (cancelled) {
+        return;
+      }
+
       // Determine the correct WebSocket protocol and host
       const protocol = window.location.protocol === "https:" ? "wss:" : "ws:";
       const host =
@@ -60,9 +108,10 @@ export const wsManagerAtom = atom(
           ? `localhost:8080`
           : window.location.host;
 
-      const wsUrl = `${protocol}//${host}/ws/${cleanRoomId}?username=${cleanUsername}`;
+      const wsUrl = `${protocol}//${host}/ws/${cleanRoomId}?ticket=${encodeURIComponent(ticket)}`;
 
       const ws = new WebSocket(wsUrl);
+      currentWs = ws;
       set(wsErrorAtom, null);
 
       const connectionTimeout = window.setTimeout(() => {
@@ -86,22 +135,8 @@ export const wsManagerAtom = atom(
         set(wsConnectedAtom, false);
 
         // Only attempt reconnection for unexpected closures
-        if (event.code !== 1000 && reconnectAttempts < MAX_RECONNECT_ATTEMPTS) {
-          reconnectAttempts++;
-          set(
-            wsErrorAtom,
-            `Connection lost. Attempt ${reconnectAttempts}/${MAX_RECONNECT_ATTEMPTS} to reconnect...`
-          );
-
-          reconnectTimeout = window.setTimeout(
-            connectWebSocket,
-            RECONNECT_DELAY
-          );
-        } else if (event.code !== 1000) {
-          set(
-            wsErrorAtom,
-            "Connection failed after multiple attempts. Please try again later."
-          );
+        if (event.code !== 1000 && !cancelled) {
+          scheduleReconnect();
         }
       };
 
@@ -123,15 +158,15 @@ export const wsManagerAtom = atom(
       // };
 
       set(wsConnectionAtom, ws);
-      return ws;
     };
 
-    const ws = connectWebSocket();
+    connectWebSocket();
 
     return () => {
+      cancelled = true;
       window.clearTimeout(reconnectTimeout);
-      if (ws.readyState === WebSocket.OPEN) {
-        ws.close(1000, "Component unmounted");
+      if (currentWs?.readyState === WebSocket.OPEN) {
+        currentWs.close(1000, "Component unmounted");
       }
     };
   }
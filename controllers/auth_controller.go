@@ -3,9 +3,11 @@ package controllers
 import (
 	"fmt"
 	"log"
-	"os"
+	"realtime-todos/helper"
 	"realtime-todos/initialisers"
+	"realtime-todos/middlewares"
 	"realtime-todos/models"
+	"realtime-todos/websockets"
 	"time"
 
 	"golang.org/x/crypto/bcrypt"
@@ -20,10 +22,7 @@ func validateRegistrationInput(username, password string) (string, bool) {
 	if username == "" || password == "" {
 		return "username and password are required", false
 	}
-	if len(password) < 6 {
-		return "password must be at least 6 characters long", false
-	}
-	return "", true
+	return currentPasswordPolicy().validate(password)
 }
 
 // Helper function to check if a user exists
@@ -45,20 +44,17 @@ func verifyPassword(password, hash string) bool {
 	return err == nil
 }
 
-func generateJWT(username string) (string, error) {
-	secretKey := os.Getenv("JWT_SECRET")
-	if secretKey == "" {
-		return "", fmt.Errorf("JWT_SECRET is not set")
-	}
-
+// generateJWT signs an access token for the session
+func generateJWT(username string, sessionID string) (string, error) {
 	claims := jwt.MapClaims{
 		"username": username,
-		"exp":      time.Now().Add(24 * 30 * time.Hour).Unix(),
+		"typ":      "access",
+		"jti":      sessionID,
+		"exp":      time.Now().Add(middlewares.AccessTokenTTL()).Unix(),
 		"iat":      time.Now().Unix(),
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	signedToken, err := token.SignedString([]byte(secretKey))
+	signedToken, err := middlewares.Keys.Sign(claims)
 	if err != nil {
 		return "", fmt.Errorf("failed to sign token: %v", err)
 	}
@@ -74,6 +70,7 @@ func Register(c *fiber.Ctx) error {
 	var body struct {
 		Username string `json:"username"`
 		Password string `json:"password"`
+		Email    string `json:"email"`
 	}
 
 	if err := c.BodyParser(&body); err != nil {
@@ -89,6 +86,13 @@ func Register(c *fiber.Ctx) error {
 		})
 	}
 
+	email, valid := normalizeEmail(body.Email)
+	if !valid {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid email address",
+		})
+	}
+
 	if db == nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Database not connected",
@@ -102,6 +106,12 @@ func Register(c *fiber.Ctx) error {
 		})
 	}
 
+	if email != nil && isEmailTaken(db, *email, 0) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Email is already in use",
+		})
+	}
+
 	//Hash password
 	hashedPassword, err := hashPassword(body.Password)
 	if err != nil {
@@ -114,6 +124,7 @@ func Register(c *fiber.Ctx) error {
 	newUser := models.User{
 		Username: body.Username,
 		Password: hashedPassword,
+		Email:    email,
 	}
 
 	if err := db.Create(&newUser).Error; err != nil {
@@ -158,8 +169,40 @@ func Login(c *fiber.Ctx) error {
 		})
 	}
 
+	// With two-factor authentication the password only earns a challenge,
+	// exchanged for tokens at /login/2fa
+	if user.TOTPEnabled {
+		challenge, err := generateMFAChallenge(db, user.Username)
+		if err != nil {
+			log.Println("Something went wrong while generating MFA challenge:", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Something went wrong",
+			})
+		}
+
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"message":     "Two-factor authentication required",
+			"mfaRequired": true,
+			"mfaToken":    challenge,
+			"expiresIn":   int(mfaChallengeTTL().Seconds()),
+		})
+	}
+
+	return completeLogin(c, db, user)
+}
+
+// completeLogin starts a session for a fully authenticated user
+func completeLogin(c *fiber.Ctx, db *gorm.DB, user models.User) error {
+	session, err := createSession(db, c, user)
+	if err != nil {
+		log.Println("Something went wrong while creating session:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Something went wrong",
+		})
+	}
+
 	//sign jwt
-	token, err := generateJWT(user.Username)
+	token, err := generateJWT(user.Username, session.TokenID)
 	if err != nil {
 		log.Println("Something went wrong while generating JWT token")
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
@@ -167,10 +210,15 @@ func Login(c *fiber.Ctx) error {
 		})
 	}
 
-	return c.Status(fiber.StatusOK).JSON(fiber.Map{
-		"message": "	Login successful",
-		"jwt":     token,
-	})
+	refreshToken, err := issueRefreshToken(db, user.ID, session.TokenID)
+	if err != nil {
+		log.Println("Something went wrong while issuing refresh token:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Something went wrong",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(tokenResponse("Login successful", token, refreshToken))
 }
 
 // Me handler
@@ -188,8 +236,218 @@ func Me(c *fiber.Ctx) error {
 	// user.Password = ""
 
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
-		"message": "Success",
-		"user":    user,
+		"message":          "Success",
+		"user":             user,
+		"email":            user.Email,
+		"twoFactorEnabled": user.TOTPEnabled,
+	})
+
+}
+
+// DeleteAccount deletes the user after confirming their password. Rooms they
+// administer pass to their longest-standing member first.
+func DeleteAccount(c *fiber.Ctx) error {
+	db := initialisers.DB
+	username, ok := helper.GetUsername(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Unauthorized",
+		})
+	}
+
+	var body struct {
+		Password string `json:"password"`
+	}
+
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Failed to parse request body",
+		})
+	}
+
+	var user models.User
+	if err := db.Preload("Rooms.Users").Where("username = ?", username).First(&user).Error; err != nil {
+		return helper.HandleError(c, err)
+	}
+
+	if !verifyPassword(body.Password, user.Password) {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid password",
+		})
+	}
+
+	wasAdmin := make([]bool, len(user.Rooms))
+	deleted := make([]bool, len(user.Rooms))
+	err := db.Transaction(func(tx *gorm.DB) error {
+		for i := range user.Rooms {
+			room := &user.Rooms[i]
+			if room.AdminID == user.ID {
+				wasAdmin[i] = true
+				var err error
+				if deleted[i], err = handOverRoom(tx, room); err != nil {
+					return err
+				}
+				if deleted[i] {
+					continue
+				}
+			}
+
+			if err := tx.Model(room).Association("Users").Delete(&user); err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Where("user_id = ?", user.ID).Delete(&models.Todo{}).Error; err != nil {
+			return err
+		}
+
+		return tx.Delete(&user).Error
+	})
+	if err != nil {
+		return helper.HandleError(c, err)
+	}
+
+	var sessions []models.Session
+	if err := db.Where("user_id = ? AND revoked_at IS NULL", user.ID).Find(&sessions).Error; err == nil {
+		if err := revokeSessions(db, sessions); err != nil {
+			log.Println("Error revoking sessions of deleted account:", err)
+		}
+	}
+
+	// Only the identity goes out, the preloaded rooms and their members must not
+	// reach the other rooms
+	leaving := models.User{Model: user.Model, Username: user.Username}
+	for i, room := range user.Rooms {
+		if wasAdmin[i] {
+			announceHandOver(room, user.ID, deleted[i])
+		}
+		if !deleted[i] {
+			websockets.BroadcastUserLeft(room.ID, leaving)
+			websockets.DisconnectUser(room, user)
+		}
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Account deleted successfully",
+	})
+}
+
+// ChangePassword sets a new password after checking the current one, and logs
+// out every other session
+func ChangePassword(c *fiber.Ctx) error {
+	db := initialisers.DB
+	username, ok := helper.GetUsername(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Unauthorized",
+		})
+	}
+
+	var body struct {
+		CurrentPassword string `json:"currentPassword"`
+		NewPassword     string `json:"newPassword"`
+	}
+
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Failed to parse request body",
+		})
+	}
+
+	var user models.User
+	if err := db.Where("username = ?", username).First(&user).Error; err != nil {
+		return helper.HandleError(c, err)
+	}
+
+	if !verifyPassword(body.CurrentPassword, user.Password) {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Current password is incorrect",
+		})
+	}
+
+	if message, valid := currentPasswordPolicy().validate(body.NewPassword); !valid {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": message,
+		})
+	}
+
+	if body.NewPassword == body.CurrentPassword {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "New password must be different from the current one",
+		})
+	}
+
+	hashedPassword, err := hashPassword(body.NewPassword)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to hash password",
+		})
+	}
+
+	if err := db.Model(&user).Update("password", hashedPassword).Error; err != nil {
+		return helper.HandleError(c, err)
+	}
+
+	// Whoever knew the old password may still be logged in elsewhere
+	current, _ := c.Locals("sessionID").(string)
+	var sessions []models.Session
+	if err := db.Where("user_id = ? AND revoked_at IS NULL AND token_id <> ?", user.ID, current).Find(&sessions).Error; err != nil {
+		return helper.HandleError(c, err)
+	}
+
+	if err := revokeSessions(db, sessions); err != nil {
+		return helper.HandleError(c, err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Password changed successfully",
 	})
+}
+
+// UpdateEmail sets or, when empty, clears the user's email address
+func UpdateEmail(c *fiber.Ctx) error {
+	db := initialisers.DB
+	username, ok := helper.GetUsername(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Unauthorized",
+		})
+	}
+
+	var body struct {
+		Email string `json:"email"`
+	}
+
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Failed to parse request body",
+		})
+	}
+
+	email, valid := normalizeEmail(body.Email)
+	if !valid {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid email address",
+		})
+	}
+
+	var user models.User
+	if err := db.Where("username = ?", username).First(&user).Error; err != nil {
+		return helper.HandleError(c, err)
+	}
+
+	if email != nil && isEmailTaken(db, *email, user.ID) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Email is already in use",
+		})
+	}
 
+	if err := db.Model(&user).Update("email", email).Error; err != nil {
+		return helper.HandleError(c, err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Email updated successfully",
+		"email":   email,
+	})
 }
@@ -0,0 +1,306 @@
+package controllers
+
+import (
+	"errors"
+	"realtime-todos/helper"
+	"realtime-todos/initialisers"
+	"realtime-todos/models"
+	"realtime-todos/websockets"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// InvitationTTL is how long an invitation can be accepted after it is sent
+const InvitationTTL = 7 * 24 * time.Hour
+
+// inviteToRoom creates a pending invitation and shows it to the invitee
+func inviteToRoom(db *gorm.DB, room models.Room, inviter models.User, invitee models.User, role string) (models.Invitation, *requestError) {
+	var pending int64
+	if err := db.Model(&models.Invitation{}).
+		Where("room_id = ? AND invitee_id = ? AND status = ? AND expires_at > ?", room.ID, invitee.ID, models.InvitationPending, time.Now()).
+		Count(&pending).Error; err != nil {
+		return models.Invitation{}, dbError(err)
+	}
+
+	if pending > 0 {
+		return models.Invitation{}, &requestError{fiber.StatusConflict, "User already has a pending invitation"}
+	}
+
+	invitation := models.Invitation{
+		RoomID:    room.ID,
+		InviterID: inviter.ID,
+		InviteeID: invitee.ID,
+		Role:      role,
+		Status:    models.InvitationPending,
+		ExpiresAt: time.Now().Add(InvitationTTL),
+	}
+
+	if err := db.Create(&invitation).Error; err != nil {
+		return invitation, &requestError{fiber.StatusInternalServerError, "Error creating the invitation"}
+	}
+
+	invitation.Inviter = inviter
+	invitation.Invitee = invitee
+	invitation.Room = room
+	invitation.Room.Users = nil
+	invitation.Room.Members = nil
+
+	websockets.NotifyInvitationReceived(invitation)
+	return invitation, nil
+}
+
+// joinRoom adds the user to the room with the role. Joining a room one is
+// already in is a no-op reported as false.
+func joinRoom(tx *gorm.DB, roomID uint, user models.User, role string) (bool, error) {
+	var existing int64
+	if err := tx.Model(&models.RoomUser{}).Where("room_id = ? AND user_id = ?", roomID, user.ID).Count(&existing).Error; err != nil {
+		return false, err
+	}
+
+	if existing > 0 {
+		return false, nil
+	}
+
+	return true, tx.Create(&models.RoomUser{RoomID: roomID, UserID: user.ID, Role: role}).Error
+}
+
+// announceJoined tells the room about its new member and puts the room on the
+// member's dashboard
+func announceJoined(db *gorm.DB, roomID uint, user models.User) {
+	websockets.BroadcastUserJoined(roomID, user)
+
+	// Reload without todos for the new member's dashboard
+	var room models.Room
+	if err := db.Preload("Users").Preload("Admin").Preload("Members").Where("id = ?", roomID).First(&room).Error; err == nil {
+		websockets.NotifyAddedToRoom(user.ID, room)
+	}
+}
+
+// expireInvitations marks the pending invitations past their expiry as
+// expired, scoped by the query
+func expireInvitations(query *gorm.DB) error {
+	return query.Model(&models.Invitation{}).
+		Where("status = ? AND expires_at <= ?", models.InvitationPending, time.Now()).
+		Update("status", models.InvitationExpired).Error
+}
+
+// GetMyInvitations lists the user's pending invitations
+func GetMyInvitations(c *fiber.Ctx) error {
+	db := initialisers.DB
+	username, ok := helper.GetUsername(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Unauthorized",
+		})
+	}
+
+	var user models.User
+	if err := db.Where("username = ?", username).First(&user).Error; err != nil {
+		return helper.HandleError(c, err)
+	}
+
+	if err := expireInvitations(db.Where("invitee_id = ?", user.ID)); err != nil {
+		return helper.HandleError(c, err)
+	}
+
+	var invitations []models.Invitation
+	// Invitations to rooms deleted since are left out
+	if err := db.Preload("Room").Preload("Inviter").
+		Joins("JOIN rooms ON rooms.id = invitations.room_id AND rooms.deleted_at IS NULL").
+		Where("invitations.invitee_id = ? AND invitations.status = ?", user.ID, models.InvitationPending).
+		Order("invitations.created_at DESC").
+		Find(&invitations).Error; err != nil {
+		return helper.HandleError(c, err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message":     "Invitations fetched successfully",
+		"invitations": invitations,
+	})
+}
+
+func AcceptInvitation(c *fiber.Ctx) error {
+	return respondToInvitation(c, true)
+}
+
+func DeclineInvitation(c *fiber.Ctx) error {
+	return respondToInvitation(c, false)
+}
+
+// respondToInvitation accepts or declines one of the user's pending invitations
+func respondToInvitation(c *fiber.Ctx, accept bool) error {
+	db := initialisers.DB
+	username, ok := helper.GetUsername(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Unauthorized",
+		})
+	}
+
+	var user models.User
+	if err := db.Where("username = ?", username).First(&user).Error; err != nil {
+		return helper.HandleError(c, err)
+	}
+
+	invitationID, err := c.ParamsInt("invitationID")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid invitation ID",
+		})
+	}
+
+	// Other users' invitations look the same as missing ones
+	var invitation models.Invitation
+	if err := db.Where("id = ? AND invitee_id = ?", invitationID, user.ID).First(&invitation).Error; err != nil {
+		return helper.HandleError(c, err)
+	}
+
+	status := models.InvitationDeclined
+	if accept {
+		status = models.InvitationAccepted
+	}
+
+	joined := false
+	err = db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+
+		// Only the first response to a live invitation counts
+		result := tx.Model(&invitation).
+			Where("status = ? AND expires_at > ?", models.InvitationPending, now).
+			Updates(map[string]interface{}{"status": status, "responded_at": now})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return &requestError{fiber.StatusGone, "Invitation is no longer pending"}
+		}
+
+		invitation.Status = status
+		invitation.RespondedAt = &now
+
+		if !accept {
+			return nil
+		}
+
+		var room models.Room
+		if err := tx.Where("id = ?", invitation.RoomID).First(&room).Error; err != nil {
+			return err
+		}
+
+		var err error
+		joined, err = joinRoom(tx, room.ID, user, invitation.Role)
+		return err
+	})
+	if err != nil {
+		var reqErr *requestError
+		if errors.As(err, &reqErr) {
+			return reqErr.send(c)
+		}
+		return helper.HandleError(c, err)
+	}
+
+	websockets.NotifyInvitationAnswered(invitation)
+	if joined {
+		announceJoined(db, invitation.RoomID, user)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message":    "Invitation " + status + " successfully",
+		"invitation": invitation,
+	})
+}
+
+// GetRoomInvitations lists the room's pending invitations for its managers
+func GetRoomInvitations(c *fiber.Ctx) error {
+	db := initialisers.DB
+	username, ok := helper.GetUsername(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Unauthorized",
+		})
+	}
+
+	roomID, err := c.ParamsInt("roomID")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid room ID",
+		})
+	}
+
+	user, room, reqErr := loadRoomMember(db, username, uint(roomID))
+	if reqErr != nil {
+		return reqErr.send(c)
+	}
+
+	if !helper.CanInRoom(user, room, helper.PermissionManageMembers) {
+		return forbidden.send(c)
+	}
+
+	if err := expireInvitations(db.Where("room_id = ?", room.ID)); err != nil {
+		return helper.HandleError(c, err)
+	}
+
+	var invitations []models.Invitation
+	if err := db.Preload("Invitee").Preload("Inviter").
+		Where("room_id = ? AND status = ?", room.ID, models.InvitationPending).
+		Order("created_at DESC").
+		Find(&invitations).Error; err != nil {
+		return helper.HandleError(c, err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message":     "Invitations fetched successfully",
+		"invitations": invitations,
+	})
+}
+
+// RevokeInvitation withdraws a pending invitation of the room
+func RevokeInvitation(c *fiber.Ctx) error {
+	db := initialisers.DB
+	username, ok := helper.GetUsername(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Unauthorized",
+		})
+	}
+
+	roomID, err := c.ParamsInt("roomID")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid room ID",
+		})
+	}
+
+	invitationID, err := c.ParamsInt("invitationID")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid invitation ID",
+		})
+	}
+
+	user, room, reqErr := loadRoomMember(db, username, uint(roomID))
+	if reqErr != nil {
+		return reqErr.send(c)
+	}
+
+	if !helper.CanInRoom(user, room, helper.PermissionManageMembers) {
+		return forbidden.send(c)
+	}
+
+	var invitation models.Invitation
+	if err := db.Where("id = ? AND room_id = ? AND status = ?", invitationID, room.ID, models.InvitationPending).First(&invitation).Error; err != nil {
+		return helper.HandleError(c, err)
+	}
+
+	if err := db.Delete(&invitation).Error; err != nil {
+		return helper.HandleError(c, err)
+	}
+
+	websockets.NotifyInvitationRevoked(invitation)
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Invitation revoked successfully",
+	})
+}
@@ -0,0 +1,355 @@
+package controllers
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"realtime-todos/helper"
+	"realtime-todos/initialisers"
+	"realtime-todos/models"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// Invite link tokens have the form "<link id>.<signature>", where the signature
+// is an HMAC of the ID and the link's random nonce. Only the nonce is stored,
+// so the token cannot be recovered from the database, and revoking a link
+// deletes the nonce with it.
+
+// loadedInviteLinkSecret is set by LoadInviteLinkSecret
+var loadedInviteLinkSecret []byte
+
+// LoadInviteLinkSecret reads INVITE_LINK_SECRET, which signs invite link
+// tokens. It is required and kept apart from the JWT keys, so no secret
+// serves two purposes and a missing one is caught at startup.
+func LoadInviteLinkSecret() error {
+	secret := os.Getenv("INVITE_LINK_SECRET")
+	if secret == "" {
+		return fmt.Errorf("INVITE_LINK_SECRET is not set")
+	}
+
+	loadedInviteLinkSecret = []byte(secret)
+	return nil
+}
+
+// inviteLinkSecret returns the secret loaded by LoadInviteLinkSecret
+func inviteLinkSecret() ([]byte, error) {
+	if len(loadedInviteLinkSecret) == 0 {
+		return nil, fmt.Errorf("INVITE_LINK_SECRET is not loaded")
+	}
+	return loadedInviteLinkSecret, nil
+}
+
+func inviteLinkSignature(secret []byte, id uint, nonce string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	fmt.Fprintf(mac, "invite-link:%d:%s", id, nonce)
+	return mac.Sum(nil)
+}
+
+func signInviteLink(link models.InviteLink) (string, error) {
+	secret, err := inviteLinkSecret()
+	if err != nil {
+		return "", err
+	}
+
+	signature := inviteLinkSignature(secret, link.ID, link.Nonce)
+	return strconv.FormatUint(uint64(link.ID), 10) + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// verifyInviteLink loads the link the token was signed for. Malformed, forged
+// and revoked tokens all look like a missing link.
+func verifyInviteLink(db *gorm.DB, token string) (models.InviteLink, *requestError) {
+	var link models.InviteLink
+	notFound := &requestError{fiber.StatusNotFound, "Invite link not found"}
+
+	idValue, signatureValue, ok := strings.Cut(token, ".")
+	if !ok {
+		return link, notFound
+	}
+
+	id, err := strconv.ParseUint(idValue, 10, 64)
+	if err != nil {
+		return link, notFound
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(signatureValue)
+	if err != nil {
+		return link, notFound
+	}
+
+	if err := db.Where("id = ?", id).First(&link).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return link, notFound
+		}
+		return link, dbError(err)
+	}
+
+	secret, err := inviteLinkSecret()
+	if err != nil {
+		return link, &requestError{fiber.StatusInternalServerError, "Internal server error"}
+	}
+
+	if !hmac.Equal(signature, inviteLinkSignature(secret, link.ID, link.Nonce)) {
+		return link, notFound
+	}
+
+	return link, nil
+}
+
+// activeInviteLinks scopes a query to links that are neither expired nor used up
+func activeInviteLinks(db *gorm.DB) *gorm.DB {
+	return db.Model(&models.InviteLink{}).
+		Where("expires_at IS NULL OR expires_at > ?", time.Now()).
+		Where("max_uses = 0 OR uses < max_uses")
+}
+
+func CreateInviteLink(c *fiber.Ctx) error {
+	db := initialisers.DB
+	username, ok := helper.GetUsername(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Unauthorized",
+		})
+	}
+
+	roomID, err := c.ParamsInt("roomID")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid room ID",
+		})
+	}
+
+	user, room, reqErr := loadRoomMember(db, username, uint(roomID))
+	if reqErr != nil {
+		return reqErr.send(c)
+	}
+
+	if !helper.CanInRoom(user, room, helper.PermissionManageMembers) {
+		return forbidden.send(c)
+	}
+
+	type RequestBody struct {
+		Role      string     `json:"role"`
+		ExpiresAt *time.Time `json:"expiresAt"`
+		MaxUses   uint       `json:"maxUses"`
+	}
+
+	var body RequestBody
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Failed to parse request body",
+		})
+	}
+
+	if body.Role == "" {
+		body.Role = models.RoleEditor
+	}
+	if !helper.IsValidRole(body.Role) || body.Role == models.RoleOwner {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid role",
+		})
+	}
+
+	if body.ExpiresAt != nil && !body.ExpiresAt.After(time.Now()) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Expiry must be in the future",
+		})
+	}
+
+	nonce := make([]byte, 32)
+	if _, err := rand.Read(nonce); err != nil {
+		return helper.HandleError(c, err)
+	}
+
+	link := models.InviteLink{
+		RoomID:    room.ID,
+		CreatorID: user.ID,
+		Role:      body.Role,
+		Nonce:     base64.RawURLEncoding.EncodeToString(nonce),
+		ExpiresAt: body.ExpiresAt,
+		MaxUses:   body.MaxUses,
+	}
+
+	if err := db.Create(&link).Error; err != nil {
+		return helper.HandleError(c, err)
+	}
+	link.Creator = user
+
+	token, err := signInviteLink(link)
+	if err != nil {
+		return helper.HandleError(c, err)
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"message": "Invite link created successfully",
+		"link":    link,
+		"token":   token,
+	})
+}
+
+// GetInviteLinks lists the room's active links along with their tokens
+func GetInviteLinks(c *fiber.Ctx) error {
+	db := initialisers.DB
+	username, ok := helper.GetUsername(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Unauthorized",
+		})
+	}
+
+	roomID, err := c.ParamsInt("roomID")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid room ID",
+		})
+	}
+
+	user, room, reqErr := loadRoomMember(db, username, uint(roomID))
+	if reqErr != nil {
+		return reqErr.send(c)
+	}
+
+	if !helper.CanInRoom(user, room, helper.PermissionManageMembers) {
+		return forbidden.send(c)
+	}
+
+	var links []models.InviteLink
+	if err := activeInviteLinks(db).Preload("Creator").Where("room_id = ?", room.ID).Order("created_at DESC").Find(&links).Error; err != nil {
+		return helper.HandleError(c, err)
+	}
+
+	type linkWithToken struct {
+		models.InviteLink
+		Token string `json:"token"`
+	}
+
+	result := make([]linkWithToken, 0, len(links))
+	for _, link := range links {
+		token, err := signInviteLink(link)
+		if err != nil {
+			return helper.HandleError(c, err)
+		}
+		result = append(result, linkWithToken{link, token})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Invite links fetched successfully",
+		"links":   result,
+	})
+}
+
+func RevokeInviteLink(c *fiber.Ctx) error {
+	db := initialisers.DB
+	username, ok := helper.GetUsername(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Unauthorized",
+		})
+	}
+
+	roomID, err := c.ParamsInt("roomID")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid room ID",
+		})
+	}
+
+	linkID, err := c.ParamsInt("linkID")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid link ID",
+		})
+	}
+
+	user, room, reqErr := loadRoomMember(db, username, uint(roomID))
+	if reqErr != nil {
+		return reqErr.send(c)
+	}
+
+	if !helper.CanInRoom(user, room, helper.PermissionManageMembers) {
+		return forbidden.send(c)
+	}
+
+	result := db.Where("id = ? AND room_id = ?", linkID, room.ID).Delete(&models.InviteLink{})
+	if result.Error != nil {
+		return helper.HandleError(c, result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Invite link not found",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Invite link revoked successfully",
+	})
+}
+
+// JoinWithInviteLink adds the user to the room of the link, using it up once
+func JoinWithInviteLink(c *fiber.Ctx) error {
+	db := initialisers.DB
+	username, ok := helper.GetUsername(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Unauthorized",
+		})
+	}
+
+	var user models.User
+	if err := db.Where("username = ?", username).First(&user).Error; err != nil {
+		return helper.HandleError(c, err)
+	}
+
+	link, reqErr := verifyInviteLink(db, c.Params("token"))
+	if reqErr != nil {
+		return reqErr.send(c)
+	}
+
+	joined := false
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var room models.Room
+		if err := tx.Where("id = ?", link.RoomID).First(&room).Error; err != nil {
+			return err
+		}
+
+		var err error
+		if joined, err = joinRoom(tx, room.ID, user, link.Role); err != nil || !joined {
+			return err
+		}
+
+		// Count the use only if the link is still active, members opening the
+		// link again do not use it up
+		result := activeInviteLinks(tx).Where("id = ?", link.ID).Update("uses", gorm.Expr("uses + 1"))
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return &requestError{fiber.StatusGone, "Invite link has expired"}
+		}
+		return nil
+	})
+	if err != nil {
+		var reqErr *requestError
+		if errors.As(err, &reqErr) {
+			return reqErr.send(c)
+		}
+		return helper.HandleError(c, err)
+	}
+
+	if joined {
+		announceJoined(db, link.RoomID, user)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Joined room successfully",
+		"roomId":  link.RoomID,
+	})
+}
@@ -0,0 +1,15 @@
+package controllers
+
+import (
+	"realtime-todos/middlewares"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// GetJWKS publishes the public keys access tokens can be verified with
+func GetJWKS(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderCacheControl, "public, max-age=300")
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"keys": middlewares.Keys.JWKS(),
+	})
+}
@@ -0,0 +1,81 @@
+package controllers
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// maxPasswordBytes is the most bcrypt will hash
+const maxPasswordBytes = 72
+
+// passwordPolicy is what a new password has to satisfy. PASSWORD_MIN_LENGTH
+// sets the minimum length and PASSWORD_BREACHED_LIST points to a file with one
+// known breached password per line, which are refused.
+type passwordPolicy struct {
+	minLength int
+	breached  map[string]bool
+}
+
+var (
+	loadedPasswordPolicy passwordPolicy
+	passwordPolicyOnce   sync.Once
+)
+
+// currentPasswordPolicy loads the policy from the environment on first use
+func currentPasswordPolicy() passwordPolicy {
+	passwordPolicyOnce.Do(func() {
+		policy := passwordPolicy{minLength: 6, breached: make(map[string]bool)}
+
+		if value := os.Getenv("PASSWORD_MIN_LENGTH"); value != "" {
+			if n, err := strconv.Atoi(value); err == nil && n > 0 && n <= maxPasswordBytes {
+				policy.minLength = n
+			} else {
+				log.Printf("Invalid PASSWORD_MIN_LENGTH %q, using %d", value, policy.minLength)
+			}
+		}
+
+		if path := os.Getenv("PASSWORD_BREACHED_LIST"); path != "" {
+			if err := policy.loadBreached(path); err != nil {
+				log.Printf("Error loading PASSWORD_BREACHED_LIST: %v", err)
+			}
+		}
+
+		loadedPasswordPolicy = policy
+	})
+	return loadedPasswordPolicy
+}
+
+func (p passwordPolicy) loadBreached(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			p.breached[strings.ToLower(line)] = true
+		}
+	}
+	return scanner.Err()
+}
+
+// validate returns why the password is not acceptable, if it is not
+func (p passwordPolicy) validate(password string) (string, bool) {
+	if len([]rune(password)) < p.minLength {
+		return fmt.Sprintf("password must be at least %d characters long", p.minLength), false
+	}
+	if len(password) > maxPasswordBytes {
+		return fmt.Sprintf("password must be at most %d bytes long", maxPasswordBytes), false
+	}
+	if p.breached[strings.ToLower(password)] {
+		return "password is too common, it appears in a list of breached passwords", false
+	}
+	return "", true
+}
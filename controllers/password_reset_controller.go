@@ -0,0 +1,234 @@
+package controllers
+
+import (
+	"fmt"
+	"log"
+	"net/mail"
+	"os"
+	"realtime-todos/helper"
+	"realtime-todos/initialisers"
+	"realtime-todos/mailer"
+	"realtime-todos/models"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// normalizeEmail validates an optional email address, returning nil for an
+// empty one
+func normalizeEmail(value string) (*string, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, true
+	}
+
+	address, err := mail.ParseAddress(value)
+	if err != nil || address.Address != value || len(value) > 255 {
+		return nil, false
+	}
+
+	email := strings.ToLower(value)
+	return &email, true
+}
+
+// isEmailTaken reports whether a user other than exceptID uses the email
+func isEmailTaken(db *gorm.DB, email string, exceptID uint) bool {
+	var count int64
+	db.Model(&models.User{}).Where("email = ? AND id <> ?", email, exceptID).Count(&count)
+	return count > 0
+}
+
+// passwordResetTTL is how long a reset link works, PASSWORD_RESET_TTL
+func passwordResetTTL() time.Duration {
+	return helper.DurationFromEnv("PASSWORD_RESET_TTL", time.Hour)
+}
+
+// passwordResetCooldown is the least time between two reset emails to the
+// same account, PASSWORD_RESET_COOLDOWN
+func passwordResetCooldown() time.Duration {
+	return helper.DurationFromEnv("PASSWORD_RESET_COOLDOWN", 5*time.Minute)
+}
+
+// claimPasswordReset reports whether the user may get a reset email now and
+// marks it sent. The conditional update lets only one of several concurrent
+// requests through.
+func claimPasswordReset(db *gorm.DB, userID uint) (bool, error) {
+	now := time.Now()
+	result := db.Model(&models.User{}).
+		Where("id = ? AND (password_reset_sent_at IS NULL OR password_reset_sent_at < ?)", userID, now.Add(-passwordResetCooldown())).
+		Update("password_reset_sent_at", now)
+	return result.RowsAffected > 0, result.Error
+}
+
+// issuePasswordReset replaces the user's unused reset tokens with a new one
+// and mails it, unless one was sent within the cooldown. It runs in the
+// background so that the response time of RequestPasswordReset does not give
+// away whether the account exists.
+func issuePasswordReset(db *gorm.DB, user models.User) {
+	claimed, err := claimPasswordReset(db, user.ID)
+	if err != nil {
+		log.Printf("Error claiming password reset for user %d: %v", user.ID, err)
+		return
+	}
+	if !claimed {
+		return
+	}
+
+	token, err := helper.NewToken()
+	if err != nil {
+		log.Printf("Error generating password reset token for user %d: %v", user.ID, err)
+		return
+	}
+
+	// Only the latest reset link works
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ? AND used_at IS NULL", user.ID).Delete(&models.PasswordResetToken{}).Error; err != nil {
+			return err
+		}
+
+		return tx.Create(&models.PasswordResetToken{
+			UserID:    user.ID,
+			TokenHash: helper.HashToken(token),
+			ExpiresAt: time.Now().Add(passwordResetTTL()),
+		}).Error
+	})
+	if err != nil {
+		log.Printf("Error storing password reset token for user %d: %v", user.ID, err)
+		return
+	}
+
+	sendPasswordReset(user, token)
+}
+
+// sendPasswordReset mails the reset token to the user, as a link when APP_URL is set
+func sendPasswordReset(user models.User, token string) {
+	body := fmt.Sprintf("Use this code to reset the password of %s:\n\n%s\n", user.Username, token)
+	if appURL := os.Getenv("APP_URL"); appURL != "" {
+		body = fmt.Sprintf("Open this link to reset the password of %s:\n\n%s/reset-password?token=%s\n", user.Username, strings.TrimSuffix(appURL, "/"), token)
+	}
+	body += fmt.Sprintf("\nIt expires in %s. If you did not ask for a reset, you can ignore this email.\n", passwordResetTTL())
+
+	err := mailer.Default.Send(mailer.Message{
+		To:      *user.Email,
+		Subject: "Reset your password",
+		Body:    body,
+	})
+	if err != nil {
+		log.Printf("Error sending password reset to user %d: %v", user.ID, err)
+	}
+}
+
+// RequestPasswordReset emails a reset token to the account with the email
+// address. The answer is the same whether or not there is one, so it cannot
+// be used to find out who has an account.
+func RequestPasswordReset(c *fiber.Ctx) error {
+	db := initialisers.DB
+
+	if mailer.Default == nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+			"error": "Password reset is not available",
+		})
+	}
+
+	var body struct {
+		Email string `json:"email"`
+	}
+
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Failed to parse request body",
+		})
+	}
+
+	email, valid := normalizeEmail(body.Email)
+	if !valid || email == nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid email address",
+		})
+	}
+
+	response := fiber.Map{
+		"message": "If an account uses this email, a reset link has been sent to it",
+	}
+
+	var user models.User
+	if err := db.Where("email = ?", *email).First(&user).Error; err != nil {
+		return c.Status(fiber.StatusOK).JSON(response)
+	}
+
+	go issuePasswordReset(db, user)
+
+	return c.Status(fiber.StatusOK).JSON(response)
+}
+
+// ConfirmPasswordReset sets a new password with a reset token and logs out
+// every session of the account
+func ConfirmPasswordReset(c *fiber.Ctx) error {
+	db := initialisers.DB
+
+	var body struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Failed to parse request body",
+		})
+	}
+
+	invalid := fiber.Map{
+		"error": "Invalid or expired reset token",
+	}
+
+	var resetToken models.PasswordResetToken
+	if err := db.Where("token_hash = ? AND used_at IS NULL AND expires_at > ?", helper.HashToken(body.Token), time.Now()).First(&resetToken).Error; err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(invalid)
+	}
+
+	if message, valid := currentPasswordPolicy().validate(body.Password); !valid {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": message,
+		})
+	}
+
+	hashedPassword, err := hashPassword(body.Password)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to hash password",
+		})
+	}
+
+	used := false
+	err = db.Transaction(func(tx *gorm.DB) error {
+		// The token only works once, even for concurrent requests
+		result := tx.Model(&resetToken).Where("used_at IS NULL").Update("used_at", time.Now())
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		used = true
+
+		return tx.Model(&models.User{}).Where("id = ?", resetToken.UserID).Update("password", hashedPassword).Error
+	})
+	if err != nil {
+		return helper.HandleError(c, err)
+	}
+	if !used {
+		return c.Status(fiber.StatusBadRequest).JSON(invalid)
+	}
+
+	var sessions []models.Session
+	if err := db.Where("user_id = ? AND revoked_at IS NULL", resetToken.UserID).Find(&sessions).Error; err != nil {
+		return helper.HandleError(c, err)
+	}
+
+	if err := revokeSessions(db, sessions); err != nil {
+		return helper.HandleError(c, err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Password reset successfully",
+	})
+}
@@ -0,0 +1,58 @@
+package controllers
+
+import (
+	"net/http/httptest"
+	"realtime-todos/mailer"
+	"realtime-todos/models"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestClaimPasswordResetHonorsCooldown(t *testing.T) {
+	f := newTodoFixture(t)
+
+	if ok, err := claimPasswordReset(f.db, f.alice.ID); !ok || err != nil {
+		t.Fatalf("first request: got %v, %v, want it claimed", ok, err)
+	}
+	if ok, err := claimPasswordReset(f.db, f.alice.ID); ok || err != nil {
+		t.Errorf("request within the cooldown: got %v, %v, want it dropped", ok, err)
+	}
+	if ok, err := claimPasswordReset(f.db, f.bob.ID); !ok || err != nil {
+		t.Errorf("other user: got %v, %v, want it claimed", ok, err)
+	}
+
+	sent := time.Now().Add(-passwordResetCooldown() - time.Minute)
+	if err := f.db.Model(&models.User{}).Where("id = ?", f.alice.ID).Update("password_reset_sent_at", sent).Error; err != nil {
+		t.Fatalf("backdating reset: %v", err)
+	}
+	if ok, err := claimPasswordReset(f.db, f.alice.ID); !ok || err != nil {
+		t.Errorf("request after the cooldown: got %v, %v, want it claimed", ok, err)
+	}
+}
+
+func TestRequestPasswordResetWithoutMailer(t *testing.T) {
+	newTodoFixture(t)
+
+	previous := mailer.Default
+	mailer.Default = nil
+	t.Cleanup(func() { mailer.Default = previous })
+
+	app := fiber.New()
+	app.Post("/api/password/reset-request", RequestPasswordReset)
+
+	req := httptest.NewRequest(fiber.MethodPost, "/api/password/reset-request", strings.NewReader(`{"email":"alice@example.com"}`))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatalf("sending request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != fiber.StatusServiceUnavailable {
+		t.Errorf("got status %d, want %d", resp.StatusCode, fiber.StatusServiceUnavailable)
+	}
+}
@@ -0,0 +1,146 @@
+package controllers
+
+import (
+	"realtime-todos/helper"
+	"realtime-todos/initialisers"
+	"realtime-todos/models"
+	"realtime-todos/websockets"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// A room can be shared read-only with people who have no account through a
+// public token. Only its hash is stored, so sharing again replaces the link
+// and the old one stops working.
+
+// ShareRoom creates the room's public link, replacing any previous one
+func ShareRoom(c *fiber.Ctx) error {
+	db := initialisers.DB
+	username, ok := helper.GetUsername(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Unauthorized",
+		})
+	}
+
+	roomID, err := c.ParamsInt("roomID")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid room ID",
+		})
+	}
+
+	user, room, reqErr := loadRoomMember(db, username, uint(roomID))
+	if reqErr != nil {
+		return reqErr.send(c)
+	}
+
+	if !helper.CanInRoom(user, room, helper.PermissionManageRoom) {
+		return forbidden.send(c)
+	}
+
+	var body struct {
+		ShowUsernames bool `json:"showUsernames"`
+	}
+
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Failed to parse request body",
+		})
+	}
+
+	token, err := helper.NewToken()
+	if err != nil {
+		return helper.HandleError(c, err)
+	}
+
+	hash := helper.HashToken(token)
+	if err := db.Model(&room).Updates(map[string]interface{}{
+		"public_token_hash": hash,
+		"public_usernames":  body.ShowUsernames,
+	}).Error; err != nil {
+		return helper.HandleError(c, err)
+	}
+
+	websockets.Hub.ClosePublicViewers(room.ID)
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"message":       "Room shared successfully",
+		"token":         token,
+		"showUsernames": body.ShowUsernames,
+	})
+}
+
+// UnshareRoom revokes the room's public link
+func UnshareRoom(c *fiber.Ctx) error {
+	db := initialisers.DB
+	username, ok := helper.GetUsername(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Unauthorized",
+		})
+	}
+
+	roomID, err := c.ParamsInt("roomID")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid room ID",
+		})
+	}
+
+	user, room, reqErr := loadRoomMember(db, username, uint(roomID))
+	if reqErr != nil {
+		return reqErr.send(c)
+	}
+
+	if !helper.CanInRoom(user, room, helper.PermissionManageRoom) {
+		return forbidden.send(c)
+	}
+
+	if err := db.Model(&room).Updates(map[string]interface{}{
+		"public_token_hash": nil,
+		"public_usernames":  false,
+	}).Error; err != nil {
+		return helper.HandleError(c, err)
+	}
+
+	websockets.Hub.ClosePublicViewers(room.ID)
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Room is no longer shared",
+	})
+}
+
+// GetPublicRoom serves a shared room's todo list without authentication
+func GetPublicRoom(c *fiber.Ctx) error {
+	db := initialisers.DB
+
+	var room models.Room
+	if err := db.Where("public_token_hash = ?", helper.HashToken(c.Params("token"))).First(&room).Error; err != nil {
+		return helper.HandleError(c, err)
+	}
+
+	query := roomTodos(db, room.ID).Order(`"order" ASC, id ASC`)
+	if room.PublicUsernames {
+		query = query.Preload("User")
+	}
+
+	var todos []models.Todo
+	if err := query.Find(&todos).Error; err != nil {
+		return helper.HandleError(c, err)
+	}
+
+	publicTodos := make([]websockets.PublicTodo, 0, len(todos))
+	for _, todo := range todos {
+		publicTodos = append(publicTodos, websockets.NewPublicTodo(room, todo, todo.User.Username))
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Room fetched successfully",
+		"room": fiber.Map{
+			"id":    room.ID,
+			"name":  room.Name,
+			"todos": publicTodos,
+		},
+	})
+}
@@ -7,6 +7,7 @@ import (
 	"realtime-todos/websockets"
 
 	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
 )
 
 func CreateRoom(c *fiber.Ctx) error {
@@ -47,16 +48,29 @@ func CreateRoom(c *fiber.Ctx) error {
 	newRoom := models.Room{
 		Name:    body.Name,
 		AdminID: user.ID,
-		Users:   []models.User{user},
 	}
 
-	if err := db.Create(&newRoom).Error; err != nil {
+	// The creator joins as owner
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&newRoom).Error; err != nil {
+			return err
+		}
+		owner := models.RoomUser{RoomID: newRoom.ID, UserID: user.ID, Role: models.RoleOwner}
+		if err := tx.Create(&owner).Error; err != nil {
+			return err
+		}
+		newRoom.Members = []models.RoomUser{owner}
+		return nil
+	})
+	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Error creating the room",
 		})
 	}
 
 	newRoom.Admin = user
+	newRoom.Users = []models.User{user}
+	websockets.NotifyAddedToRoom(user.ID, newRoom)
 
 	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
 		"message": "Room created successfully",
@@ -87,21 +101,28 @@ func DeleteRoom(c *fiber.Ctx) error {
 
 	var room models.Room
 	// Convert roomID to uint before querying
-	if err := db.Where("id = ?", roomID).First(&room).Error; err != nil {
+	if err := db.Preload("Users").Preload("Members").Where("id = ?", roomID).First(&room).Error; err != nil {
 		return helper.HandleError(c, err)
 	}
 
-	if room.AdminID != user.ID {
+	if !helper.IsUserInRoom(user, room) {
 		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
 			"error": "Unauthorized",
 		})
 	}
 
+	if !helper.CanInRoom(user, room, helper.PermissionManageRoom) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Forbidden",
+		})
+	}
+
 	if err := db.Delete(&room).Error; err != nil {
 		return helper.HandleError(c, err)
 	}
 
 	websockets.BroadcastRoomDeleted(room)
+	websockets.NotifyRoomDeleted(room)
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
 		"message": "Room deleted successfully",
 		"room":    room,
@@ -125,7 +146,7 @@ func GetRoom(c *fiber.Ctx) error {
 	roomID := c.Params("roomID")
 
 	var room models.Room
-	if err := db.Preload("Users.Todos").Preload("Admin").Where("id = ?", roomID).First(&room).Error; err != nil {
+	if err := preloadRoomTodos(db, roomID).Preload("Admin").Preload("Members").Where("id = ?", roomID).First(&room).Error; err != nil {
 		return helper.HandleError(c, err)
 	}
 
@@ -158,7 +179,7 @@ func UpdateRoom(c *fiber.Ctx) error {
 	roomID := c.Params("roomID")
 
 	var room models.Room
-	if err := db.Preload("Users.Todos").Where("id = ?", roomID).First(&room).Error; err != nil {
+	if err := db.Preload("Users").Preload("Members").Where("id = ?", roomID).First(&room).Error; err != nil {
 		return helper.HandleError(c, err)
 	}
 
@@ -168,6 +189,12 @@ func UpdateRoom(c *fiber.Ctx) error {
 		})
 	}
 
+	if !helper.CanInRoom(user, room, helper.PermissionManageRoom) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Forbidden",
+		})
+	}
+
 	type RequestBody struct {
 		Name string `json:"name"`
 	}
@@ -187,13 +214,14 @@ func UpdateRoom(c *fiber.Ctx) error {
 
 	room.Name = body.Name
 
-	if err := db.Save(&room).Error; err != nil {
+	if err := db.Model(&room).Update("name", room.Name).Error; err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Error saving the room",
 		})
 	}
 
 	websockets.BroadcastRoomNameUpdated(room)
+	websockets.NotifyRoomRenamed(room)
 
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
 		"message": "Room updated successfully",
@@ -218,7 +246,7 @@ func GetRoomTodos(c *fiber.Ctx) error {
 	roomID := c.Params("roomID")
 
 	var room models.Room
-	if err := db.Preload("Users.Todos").Where("id = ?", roomID).First(&room).Error; err != nil {
+	if err := preloadRoomTodos(db, roomID).Where("id = ?", roomID).First(&room).Error; err != nil {
 		return helper.HandleError(c, err)
 	}
 
@@ -245,67 +273,34 @@ func AddTodo(c *fiber.Ctx) error {
 	username, ok := helper.GetUsername(c)
 	if !ok {
 		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"error": "Unauthorized USERNAME ERROR",
+			"error": "Unauthorized",
 		})
 	}
 
-	var user models.User
-	if err := db.Where("username = ?", username).First(&user).Error; err != nil {
-		return helper.HandleError(c, err)
-	}
-
-	roomID := c.Params("roomID")
-
-	var room models.Room
-	if err := db.Preload("Users.Todos").Where("id = ?", roomID).First(&room).Error; err != nil {
-		return helper.HandleError(c, err)
-	}
-
-	if !helper.IsUserInRoom(user, room) {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"error": "Unauthorized NOT IN ROOM",
+	roomID, err := c.ParamsInt("roomID")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid room ID",
 		})
 	}
 
-	type RequestBody struct {
-		Title string `json:"title"`
-		Order uint   `json:"order"`
+	user, room, reqErr := loadRoomMember(db, username, uint(roomID))
+	if reqErr != nil {
+		return reqErr.send(c)
 	}
 
-	var body RequestBody
+	var body createTodoInput
 	if err := c.BodyParser(&body); err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"error": "Failed to parse request body",
 		})
 	}
 
-	if body.Title == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Title is required",
-		})
-	}
-
-	var todo = models.Todo{
-		RoomID:      room.ID,
-		UserID:      user.ID,
-		Title:       body.Title,
-		IsCompleted: false,
-		Order:       body.Order,
-	}
-
-	if err := db.Create(&todo).Error; err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Error creating the todo",
-		})
+	todo, reqErr := createTodo(db, user, room, body)
+	if reqErr != nil {
+		return reqErr.send(c)
 	}
 
-	// Refresh room data to get updated todos
-	if err := db.Preload("Users.Todos").Where("id = ?", roomID).First(&room).Error; err != nil {
-		return helper.HandleError(c, err)
-	}
-
-	websockets.BroadcastTodosUpdated(room)
-
 	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
 		"message": "Todo created successfully",
 		"todo":    todo,
@@ -321,43 +316,29 @@ func RemoveTodo(c *fiber.Ctx) error {
 		})
 	}
 
-	var user models.User
-	if err := db.Where("username = ?", username).First(&user).Error; err != nil {
-		return helper.HandleError(c, err)
-	}
-
-	roomID := c.Params("roomID")
-
-	var room models.Room
-	if err := db.Preload("Users.Todos").Where("id = ?", roomID).First(&room).Error; err != nil {
-		return helper.HandleError(c, err)
-	}
-
-	if !helper.IsUserInRoom(user, room) {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"error": "Unauthorized",
+	roomID, err := c.ParamsInt("roomID")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid room ID",
 		})
 	}
 
-	todoID := c.Params("todoID")
-
-	var todo models.Todo
-	if err := db.Where("id = ?", todoID).First(&todo).Error; err != nil {
-		return helper.HandleError(c, err)
+	user, room, reqErr := loadRoomMember(db, username, uint(roomID))
+	if reqErr != nil {
+		return reqErr.send(c)
 	}
 
-	if err := db.Delete(&todo).Error; err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Error deleting the todo",
+	todoID, err := c.ParamsInt("todoID")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid todo ID",
 		})
 	}
 
-	// Refresh room data to get updated todos
-	if err := db.Preload("Users.Todos").Where("id = ?", roomID).First(&room).Error; err != nil {
-		return helper.HandleError(c, err)
+	if reqErr := deleteTodo(db, user, room, uint(todoID)); reqErr != nil {
+		return reqErr.send(c)
 	}
 
-	websockets.BroadcastTodosUpdated(room)
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
 		"message": "Todo deleted successfully",
 	})
@@ -372,73 +353,37 @@ func UpdateTodo(c *fiber.Ctx) error {
 		})
 	}
 
-	var user models.User
-	if err := db.Where("username = ?", username).First(&user).Error; err != nil {
-		return helper.HandleError(c, err)
-	}
-
-	roomID := c.Params("roomID")
-
-	var room models.Room
-	if err := db.Preload("Users.Todos").Where("id = ?", roomID).First(&room).Error; err != nil {
-		return helper.HandleError(c, err)
-	}
-
-	if !helper.IsUserInRoom(user, room) {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"error": "Unauthorized",
+	roomID, err := c.ParamsInt("roomID")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid room ID",
 		})
 	}
 
-	todoID := c.Params("todoID")
-
-	var todo models.Todo
-	if err := db.Where("id = ?", todoID).First(&todo).Error; err != nil {
-		return helper.HandleError(c, err)
+	user, room, reqErr := loadRoomMember(db, username, uint(roomID))
+	if reqErr != nil {
+		return reqErr.send(c)
 	}
 
-	type RequestBody struct {
-		Title       *string `json:"title"`       // Make pointer to handle optional fields
-		IsCompleted *bool   `json:"isCompleted"` // Make pointer to handle optional fields
-		Order       *uint   `json:"order"`       // Make pointer to handle optional fields
+	todoID, err := c.ParamsInt("todoID")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid todo ID",
+		})
 	}
 
-	var body RequestBody
+	var body updateTodoInput
 	if err := c.BodyParser(&body); err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"error": "Failed to parse request body",
 		})
 	}
 
-	if body.Title != nil {
-		if *body.Title == "" {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "Title cannot be empty",
-			})
-		}
-		todo.Title = *body.Title
-	}
-
-	if body.IsCompleted != nil {
-		todo.IsCompleted = *body.IsCompleted
-	}
-
-	if body.Order != nil {
-		todo.Order = *body.Order
-	}
-
-	if err := db.Save(&todo).Error; err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Error saving the todo",
-		})
-	}
-
-	if err := db.Preload("Users.Todos").Where("id = ?", roomID).First(&room).Error; err != nil {
-		return helper.HandleError(c, err)
+	todo, reqErr := updateTodo(db, user, room, uint(todoID), body)
+	if reqErr != nil {
+		return reqErr.send(c)
 	}
 
-	websockets.BroadcastTodosUpdated(room)
-
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
 		"message": "Todo updated successfully",
 		"todo":    todo,
@@ -456,10 +401,14 @@ func GetRooms(c *fiber.Ctx) error {
 	}
 
 	var user models.User
-	if err := db.Preload("Rooms.Users.Todos").Preload("Rooms.Admin").Where("username = ?", username).First(&user).Error; err != nil {
+	if err := db.Preload("Rooms.Users.Todos").Preload("Rooms.Admin").Preload("Rooms.Members").Where("username = ?", username).First(&user).Error; err != nil {
 		return helper.HandleError(c, err)
 	}
 
+	for i := range user.Rooms {
+		scopeRoomTodos(&user.Rooms[i])
+	}
+
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
 		"message": "Rooms fetched successfully",
 		"rooms":   user.Rooms,
@@ -483,7 +432,7 @@ func AddUserToRoom(c *fiber.Ctx) error {
 	roomID := c.Params("roomID")
 
 	var room models.Room
-	if err := db.Preload("Users.Todos").Where("id = ?", roomID).First(&room).Error; err != nil {
+	if err := db.Preload("Users").Preload("Members").Where("id = ?", roomID).First(&room).Error; err != nil {
 		return helper.HandleError(c, err)
 	}
 
@@ -493,8 +442,15 @@ func AddUserToRoom(c *fiber.Ctx) error {
 		})
 	}
 
+	if !helper.CanInRoom(user, room, helper.PermissionManageMembers) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Forbidden",
+		})
+	}
+
 	type RequestBody struct {
 		Username string `json:"username"`
+		Role     string `json:"role"`
 	}
 
 	var body RequestBody
@@ -510,33 +466,37 @@ func AddUserToRoom(c *fiber.Ctx) error {
 		})
 	}
 
-	var userToAdd models.User
-	if err := db.Where("username = ?", body.Username).First(&userToAdd).Error; err != nil {
-		return helper.HandleError(c, err)
+	// New members are editors unless asked otherwise, ownership only
+	// changes hands through a transfer
+	if body.Role == "" {
+		body.Role = models.RoleEditor
 	}
-
-	for _, user := range room.Users {
-		if user.ID == userToAdd.ID {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "User is already in the room",
-			})
-		}
+	if !helper.IsValidRole(body.Role) || body.Role == models.RoleOwner {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid role",
+		})
 	}
 
-	if err := db.Model(&room).Association("Users").Append(&userToAdd); err != nil {
+	var invitee models.User
+	if err := db.Where("username = ?", body.Username).First(&invitee).Error; err != nil {
 		return helper.HandleError(c, err)
 	}
 
-	// Refresh room data
-	if err := db.Preload("Users.Todos").Where("id = ?", roomID).First(&room).Error; err != nil {
-		return helper.HandleError(c, err)
+	if helper.IsUserInRoom(invitee, room) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "User is already in the room",
+		})
 	}
 
-	websockets.BroadcastUserJoined(room)
+	// Users join through an invitation they accept, see AcceptInvitation
+	invitation, reqErr := inviteToRoom(db, room, user, invitee, body.Role)
+	if reqErr != nil {
+		return reqErr.send(c)
+	}
 
 	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
-		"message": "User added successfully",
-		"user":    userToAdd,
+		"message":    "Invitation sent successfully",
+		"invitation": invitation,
 	})
 }
 
@@ -584,20 +544,47 @@ func LeaveRoom(c *fiber.Ctx) error {
 		})
 	}
 
+	// Members can only leave themselves, removing others is RemoveUserFromRoom
+	if body.Username != user.Username {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Forbidden",
+		})
+	}
+
 	var userToRemove models.User
 	if err := db.Where("username = ?", body.Username).First(&userToRemove).Error; err != nil {
 		return helper.HandleError(c, err)
 	}
 
-	if err := db.Model(&room).Association("Users").Delete(&userToRemove); err != nil {
-		return helper.HandleError(c, err)
-	}
+	// The admin hands the room over before leaving, the last member deletes it
+	wasAdmin := room.AdminID == userToRemove.ID
+	deleted := false
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if wasAdmin {
+			var err error
+			if deleted, err = handOverRoom(tx, &room); err != nil || deleted {
+				return err
+			}
+		}
 
-	if err := db.Where("room_id = ? AND user_id = ?", room.ID, userToRemove.ID).Delete(&models.Todo{}).Error; err != nil {
+		if err := tx.Model(&room).Association("Users").Delete(&userToRemove); err != nil {
+			return err
+		}
+
+		return tx.Where("room_id = ? AND user_id = ?", room.ID, userToRemove.ID).Delete(&models.Todo{}).Error
+	})
+	if err != nil {
 		return helper.HandleError(c, err)
 	}
 
-	websockets.BroadcastUserLeft(room)
+	if wasAdmin {
+		announceHandOver(room, userToRemove.ID, deleted)
+	}
+	if !deleted {
+		websockets.BroadcastUserLeft(room.ID, userToRemove)
+		websockets.NotifyRemovedFromRoom(userToRemove.ID, room)
+		websockets.DisconnectUser(room, userToRemove)
+	}
 
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
 		"message": "User removed successfully",
@@ -623,7 +610,7 @@ func RemoveUserFromRoom(c *fiber.Ctx) error {
 	roomID := c.Params("roomID")
 
 	var room models.Room
-	if err := db.Preload("Users").Where("id = ?", roomID).First(&room).Error; err != nil {
+	if err := db.Preload("Users").Preload("Members").Where("id = ?", roomID).First(&room).Error; err != nil {
 		return helper.HandleError(c, err)
 	}
 
@@ -633,6 +620,12 @@ func RemoveUserFromRoom(c *fiber.Ctx) error {
 		})
 	}
 
+	if !helper.CanInRoom(user, room, helper.PermissionManageMembers) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Forbidden",
+		})
+	}
+
 	type RequestBody struct {
 		Username string `json:"username"`
 	}
@@ -655,6 +648,12 @@ func RemoveUserFromRoom(c *fiber.Ctx) error {
 		return helper.HandleError(c, err)
 	}
 
+	if userToRemove.ID == room.AdminID {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "The owner cannot be removed",
+		})
+	}
+
 	if err := db.Model(&room).Association("Users").Delete(&userToRemove); err != nil {
 		return helper.HandleError(c, err)
 	}
@@ -663,7 +662,9 @@ func RemoveUserFromRoom(c *fiber.Ctx) error {
 		return helper.HandleError(c, err)
 	}
 
-	websockets.BroadcastUserLeft(room)
+	websockets.BroadcastUserLeft(room.ID, userToRemove)
+	websockets.NotifyRemovedFromRoom(userToRemove.ID, room)
+	websockets.DisconnectUser(room, userToRemove)
 
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
 		"message": "User removed successfully",
@@ -673,7 +674,44 @@ func RemoveUserFromRoom(c *fiber.Ctx) error {
 
 func ReorderTodos(c *fiber.Ctx) error {
 	db := initialisers.DB
+	username, ok := helper.GetUsername(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Unauthorized",
+		})
+	}
+
+	roomID, err := c.ParamsInt("roomID")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid room ID",
+		})
+	}
+
+	user, room, reqErr := loadRoomMember(db, username, uint(roomID))
+	if reqErr != nil {
+		return reqErr.send(c)
+	}
+
+	var request struct {
+		Todos []todoOrder `json:"todos"`
+	}
+
+	if err := c.BodyParser(&request); err != nil {
+		return helper.HandleError(c, err)
+	}
+
+	if reqErr := reorderTodos(db, user, room, request.Todos); reqErr != nil {
+		return reqErr.send(c)
+	}
 
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Todos reordered successfully",
+	})
+}
+
+func GetRoomPresence(c *fiber.Ctx) error {
+	db := initialisers.DB
 	username, ok := helper.GetUsername(c)
 	if !ok {
 		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
@@ -689,7 +727,7 @@ func ReorderTodos(c *fiber.Ctx) error {
 	roomID := c.Params("roomID")
 
 	var room models.Room
-	if err := db.Preload("Users.Todos").Where("id = ?", roomID).First(&room).Error; err != nil {
+	if err := db.Preload("Users").Where("id = ?", roomID).First(&room).Error; err != nil {
 		return helper.HandleError(c, err)
 	}
 
@@ -699,33 +737,96 @@ func ReorderTodos(c *fiber.Ctx) error {
 		})
 	}
 
-	// Create a struct to match the frontend data structure
-	var request struct {
-		Todos []struct {
-			ID    uint `json:"id"`
-			Order uint `json:"order"`
-		} `json:"todos"`
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Presence fetched successfully",
+		"users":   websockets.Hub.Presence(room.ID),
+	})
+}
+
+func UpdateMemberRole(c *fiber.Ctx) error {
+	db := initialisers.DB
+	username, ok := helper.GetUsername(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Unauthorized",
+		})
 	}
 
-	if err := c.BodyParser(&request); err != nil {
+	var user models.User
+	if err := db.Where("username = ?", username).First(&user).Error; err != nil {
 		return helper.HandleError(c, err)
 	}
 
-	// Update the order of each todo in the database
-	for _, update := range request.Todos {
-		if err := db.Model(&models.Todo{}).Where("id = ?", update.ID).Update("order", update.Order).Error; err != nil {
-			return helper.HandleError(c, err)
-		}
+	roomID := c.Params("roomID")
+
+	var room models.Room
+	if err := db.Preload("Users").Preload("Members").Where("id = ?", roomID).First(&room).Error; err != nil {
+		return helper.HandleError(c, err)
+	}
+
+	if !helper.IsUserInRoom(user, room) {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Unauthorized",
+		})
+	}
+
+	if !helper.CanInRoom(user, room, helper.PermissionManageMembers) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Forbidden",
+		})
+	}
+
+	type RequestBody struct {
+		Username string `json:"username"`
+		Role     string `json:"role"`
+	}
+
+	var body RequestBody
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Failed to parse request body",
+		})
+	}
+
+	if body.Username == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Username is required",
+		})
 	}
 
-	// Refresh room data to get updated todos
-	if err := db.Preload("Users.Todos").Where("id = ?", roomID).First(&room).Error; err != nil {
+	if !helper.IsValidRole(body.Role) || body.Role == models.RoleOwner {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid role",
+		})
+	}
+
+	var member models.User
+	if err := db.Where("username = ?", body.Username).First(&member).Error; err != nil {
 		return helper.HandleError(c, err)
 	}
 
-	websockets.BroadcastTodosUpdated(room)
+	if !helper.IsUserInRoom(member, room) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "User is not in the room",
+		})
+	}
+
+	if member.ID == room.AdminID {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "The owner's role cannot be changed",
+		})
+	}
+
+	roomUser := models.RoomUser{RoomID: room.ID, UserID: member.ID}
+	if err := db.Model(&roomUser).Where("room_id = ? AND user_id = ?", room.ID, member.ID).Update("role", body.Role).Error; err != nil {
+		return helper.HandleError(c, err)
+	}
+	roomUser.Role = body.Role
+
+	websockets.BroadcastMemberRoleUpdated(room.ID, roomUser)
 
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
-		"message": "Todos reordered successfully",
+		"message": "Role updated successfully",
+		"member":  roomUser,
 	})
 }
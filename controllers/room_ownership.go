@@ -0,0 +1,163 @@
+package controllers
+
+import (
+	"errors"
+	"realtime-todos/helper"
+	"realtime-todos/initialisers"
+	"realtime-todos/models"
+	"realtime-todos/websockets"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// A room always has an admin who is also its owner member. Ownership moves
+// through TransferRoom, or to the longest-standing member when the admin
+// leaves the room or deletes their account.
+
+// transferAdmin makes newAdmin the admin and owner of the room. The previous
+// admin stays a member as an editor, unless they are on their way out.
+func transferAdmin(tx *gorm.DB, room *models.Room, newAdmin models.User) error {
+	if err := tx.Model(&models.RoomUser{}).Where("room_id = ? AND user_id = ?", room.ID, room.AdminID).Update("role", models.RoleEditor).Error; err != nil {
+		return err
+	}
+
+	if err := tx.Model(&models.RoomUser{}).Where("room_id = ? AND user_id = ?", room.ID, newAdmin.ID).Update("role", models.RoleOwner).Error; err != nil {
+		return err
+	}
+
+	if err := tx.Model(room).Update("admin_id", newAdmin.ID).Error; err != nil {
+		return err
+	}
+
+	room.AdminID = newAdmin.ID
+	room.Admin = newAdmin
+	return nil
+}
+
+// longestStandingMember returns the member who joined the room first, other
+// than the user. Members from before join dates were recorded come first.
+func longestStandingMember(tx *gorm.DB, roomID uint, exceptUserID uint) (models.User, bool, error) {
+	var user models.User
+	err := tx.Joins("JOIN room_users ON room_users.user_id = users.id").
+		Where("room_users.room_id = ? AND users.id <> ?", roomID, exceptUserID).
+		Order("room_users.created_at ASC NULLS FIRST, users.id ASC").
+		First(&user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return user, false, nil
+	}
+	return user, err == nil, err
+}
+
+// handOverRoom runs before the admin leaves the room. Ownership passes to the
+// longest-standing member, or the room and its memberships are deleted when
+// nobody else is left, which is reported back.
+func handOverRoom(tx *gorm.DB, room *models.Room) (bool, error) {
+	successor, ok, err := longestStandingMember(tx, room.ID, room.AdminID)
+	if err != nil {
+		return false, err
+	}
+
+	if !ok {
+		if err := tx.Where("room_id = ?", room.ID).Delete(&models.RoomUser{}).Error; err != nil {
+			return false, err
+		}
+		return true, tx.Delete(room).Error
+	}
+
+	return false, transferAdmin(tx, room, successor)
+}
+
+// announceHandOver sends the events for the outcome of handOverRoom once it is
+// committed. The room needs Users preloaded.
+func announceHandOver(room models.Room, previousAdminID uint, deleted bool) {
+	if deleted {
+		websockets.BroadcastRoomDeleted(room)
+		websockets.NotifyRoomDeleted(room)
+		return
+	}
+
+	websockets.BroadcastRoomAdminChanged(room, previousAdminID)
+	websockets.NotifyRoomAdminChanged(room, previousAdminID)
+}
+
+func TransferRoom(c *fiber.Ctx) error {
+	db := initialisers.DB
+	username, ok := helper.GetUsername(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Unauthorized",
+		})
+	}
+
+	var user models.User
+	if err := db.Where("username = ?", username).First(&user).Error; err != nil {
+		return helper.HandleError(c, err)
+	}
+
+	roomID := c.Params("roomID")
+
+	var room models.Room
+	if err := db.Preload("Users").Where("id = ?", roomID).First(&room).Error; err != nil {
+		return helper.HandleError(c, err)
+	}
+
+	if !helper.IsUserInRoom(user, room) {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Unauthorized",
+		})
+	}
+
+	if room.AdminID != user.ID {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Only the room admin can transfer ownership",
+		})
+	}
+
+	type RequestBody struct {
+		Username string `json:"username"`
+	}
+
+	var body RequestBody
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Failed to parse request body",
+		})
+	}
+
+	if body.Username == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Username is required",
+		})
+	}
+
+	var newAdmin models.User
+	if err := db.Where("username = ?", body.Username).First(&newAdmin).Error; err != nil {
+		return helper.HandleError(c, err)
+	}
+
+	if !helper.IsUserInRoom(newAdmin, room) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "User is not in the room",
+		})
+	}
+
+	if newAdmin.ID == user.ID {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "User is already the room admin",
+		})
+	}
+
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		return transferAdmin(tx, &room, newAdmin)
+	}); err != nil {
+		return helper.HandleError(c, err)
+	}
+
+	announceHandOver(room, user.ID, false)
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Ownership transferred successfully",
+		"room":    room,
+	})
+}
@@ -0,0 +1,27 @@
+package controllers
+
+import (
+	"realtime-todos/models"
+	"testing"
+)
+
+func TestHandOverRoomDeletesMembershipsOfEmptyRoom(t *testing.T) {
+	f := newTodoFixture(t)
+
+	room := f.roomB
+	deleted, err := handOverRoom(f.db, &room)
+	if err != nil {
+		t.Fatalf("handing over room: %v", err)
+	}
+	if !deleted {
+		t.Fatal("room with no other member was not deleted")
+	}
+
+	var memberships int64
+	if err := f.db.Model(&models.RoomUser{}).Where("room_id = ?", room.ID).Count(&memberships).Error; err != nil {
+		t.Fatalf("counting memberships: %v", err)
+	}
+	if memberships != 0 {
+		t.Errorf("got %d memberships of the deleted room, want 0", memberships)
+	}
+}
@@ -0,0 +1,71 @@
+package controllers
+
+import (
+	"errors"
+	"realtime-todos/models"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// Todos are only ever read or written through the helpers below, which
+// constrain every query by room_id. A todo ID from another room behaves
+// exactly like one that does not exist.
+
+// todoNotFound is returned for todos that are missing or belong to another room
+var todoNotFound = &requestError{fiber.StatusNotFound, "Todo not found"}
+
+// roomTodos scopes a query to the todos of the room
+func roomTodos(db *gorm.DB, roomID uint) *gorm.DB {
+	return db.Model(&models.Todo{}).Where("room_id = ?", roomID)
+}
+
+// findRoomTodo loads a todo of the room
+func findRoomTodo(db *gorm.DB, roomID uint, todoID uint) (models.Todo, *requestError) {
+	var todo models.Todo
+	if err := roomTodos(db, roomID).Where("id = ?", todoID).First(&todo).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return todo, todoNotFound
+		}
+		return todo, dbError(err)
+	}
+	return todo, nil
+}
+
+// checkRoomTodos makes sure every ID belongs to a todo of the room
+func checkRoomTodos(db *gorm.DB, roomID uint, ids []uint) *requestError {
+	unique := make(map[uint]bool, len(ids))
+	for _, id := range ids {
+		unique[id] = true
+	}
+
+	var count int64
+	if err := roomTodos(db, roomID).Where("id IN ?", ids).Count(&count).Error; err != nil {
+		return dbError(err)
+	}
+
+	if count != int64(len(unique)) {
+		return todoNotFound
+	}
+	return nil
+}
+
+// preloadRoomTodos preloads the members' todos of the room only, without their
+// todos in other rooms
+func preloadRoomTodos(db *gorm.DB, roomID string) *gorm.DB {
+	return db.Preload("Users.Todos", "room_id = ?", roomID)
+}
+
+// scopeRoomTodos drops todos of other rooms from members loaded with
+// Preload("Rooms.Users.Todos"), which cannot filter per room
+func scopeRoomTodos(room *models.Room) {
+	for i := range room.Users {
+		todos := make([]models.Todo, 0, len(room.Users[i].Todos))
+		for _, todo := range room.Users[i].Todos {
+			if todo.RoomID == room.ID {
+				todos = append(todos, todo)
+			}
+		}
+		room.Users[i].Todos = todos
+	}
+}
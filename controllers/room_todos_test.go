@@ -0,0 +1,184 @@
+package controllers
+
+import (
+	"realtime-todos/initialisers"
+	"realtime-todos/models"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// todoFixture is two rooms with one member and one todo each. Alice only
+// belongs to room A, Bob only to room B.
+type todoFixture struct {
+	db    *gorm.DB
+	alice models.User
+	bob   models.User
+	roomA models.Room
+	roomB models.Room
+	todoA models.Todo
+	todoB models.Todo
+}
+
+// newTodoFixture sets up an in-memory database, also as initialisers.DB for
+// the handlers that read it
+func newTodoFixture(t *testing.T) *todoFixture {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+
+	// Every connection to :memory: is a separate database
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("getting database pool: %v", err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+
+	if err := models.SetupJoinTables(db); err != nil {
+		t.Fatalf("setting up join tables: %v", err)
+	}
+	if err := db.AutoMigrate(&models.User{}, &models.Room{}, &models.RoomUser{}, &models.Todo{}, &models.EditLock{}); err != nil {
+		t.Fatalf("migrating: %v", err)
+	}
+
+	previous := initialisers.DB
+	initialisers.DB = db
+	t.Cleanup(func() { initialisers.DB = previous })
+
+	f := &todoFixture{db: db}
+	f.alice = f.createUser(t, "alice")
+	f.bob = f.createUser(t, "bob")
+	f.roomA = f.createRoom(t, "Room A", f.alice)
+	f.roomB = f.createRoom(t, "Room B", f.bob)
+	f.todoA = f.createTodo(t, f.roomA, f.alice, "Todo in A")
+	f.todoB = f.createTodo(t, f.roomB, f.bob, "Todo in B")
+	return f
+}
+
+func (f *todoFixture) createUser(t *testing.T, username string) models.User {
+	t.Helper()
+
+	user := models.User{Username: username, Password: "unused"}
+	if err := f.db.Create(&user).Error; err != nil {
+		t.Fatalf("creating user %s: %v", username, err)
+	}
+	return user
+}
+
+func (f *todoFixture) createRoom(t *testing.T, name string, owner models.User) models.Room {
+	t.Helper()
+
+	room := models.Room{Name: name, AdminID: owner.ID}
+	if err := f.db.Create(&room).Error; err != nil {
+		t.Fatalf("creating room %s: %v", name, err)
+	}
+	if err := f.db.Create(&models.RoomUser{RoomID: room.ID, UserID: owner.ID, Role: models.RoleOwner}).Error; err != nil {
+		t.Fatalf("adding %s to room %s: %v", owner.Username, name, err)
+	}
+	return room
+}
+
+func (f *todoFixture) createTodo(t *testing.T, room models.Room, user models.User, title string) models.Todo {
+	t.Helper()
+
+	todo := models.Todo{RoomID: room.ID, UserID: user.ID, Title: title, Order: 1}
+	if err := f.db.Create(&todo).Error; err != nil {
+		t.Fatalf("creating todo %s: %v", title, err)
+	}
+	return todo
+}
+
+// member loads the user and room the way the handlers do
+func (f *todoFixture) member(t *testing.T, username string, room models.Room) (models.User, models.Room) {
+	t.Helper()
+
+	user, loaded, reqErr := loadRoomMember(f.db, username, room.ID)
+	if reqErr != nil {
+		t.Fatalf("loading %s in room %d: %v", username, room.ID, reqErr)
+	}
+	return user, loaded
+}
+
+// reload fetches the todo as it is stored now, including soft-deleted ones
+func (f *todoFixture) reload(t *testing.T, id uint) models.Todo {
+	t.Helper()
+
+	var todo models.Todo
+	if err := f.db.Unscoped().First(&todo, id).Error; err != nil {
+		t.Fatalf("reloading todo %d: %v", id, err)
+	}
+	return todo
+}
+
+// assertUntouched fails if the todo changed since the fixture created it
+func (f *todoFixture) assertUntouched(t *testing.T, want models.Todo) {
+	t.Helper()
+
+	got := f.reload(t, want.ID)
+	if got.DeletedAt.Valid {
+		t.Errorf("todo %d was deleted", want.ID)
+	}
+	if got.Title != want.Title || got.Order != want.Order || got.IsCompleted != want.IsCompleted {
+		t.Errorf("todo %d changed: got %+v, want title %q order %d completed %v", want.ID, got, want.Title, want.Order, want.IsCompleted)
+	}
+}
+
+func TestFindRoomTodo(t *testing.T) {
+	f := newTodoFixture(t)
+
+	todo, reqErr := findRoomTodo(f.db, f.roomA.ID, f.todoA.ID)
+	if reqErr != nil {
+		t.Fatalf("finding own todo: %v", reqErr)
+	}
+	if todo.ID != f.todoA.ID {
+		t.Errorf("got todo %d, want %d", todo.ID, f.todoA.ID)
+	}
+
+	tests := []struct {
+		name   string
+		todoID uint
+	}{
+		{"todo of another room", f.todoB.ID},
+		{"missing todo", f.todoB.ID + 100},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, reqErr := findRoomTodo(f.db, f.roomA.ID, tt.todoID)
+			if reqErr != todoNotFound {
+				t.Errorf("got %v, want todoNotFound", reqErr)
+			}
+		})
+	}
+}
+
+func TestCheckRoomTodos(t *testing.T) {
+	f := newTodoFixture(t)
+	ownTodo := f.createTodo(t, f.roomA, f.alice, "Another todo in A")
+
+	tests := []struct {
+		name string
+		ids  []uint
+		want *requestError
+	}{
+		{"own todos", []uint{f.todoA.ID, ownTodo.ID}, nil},
+		{"repeated own todo", []uint{f.todoA.ID, f.todoA.ID}, nil},
+		{"todo of another room", []uint{f.todoB.ID}, todoNotFound},
+		{"own todos mixed with another room's", []uint{f.todoA.ID, f.todoB.ID, ownTodo.ID}, todoNotFound},
+		{"missing todo", []uint{f.todoA.ID, f.todoB.ID + 100}, todoNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := checkRoomTodos(f.db, f.roomA.ID, tt.ids); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
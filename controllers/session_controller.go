@@ -0,0 +1,209 @@
+package controllers
+
+import (
+	"realtime-todos/helper"
+	"realtime-todos/initialisers"
+	"realtime-todos/middlewares"
+	"realtime-todos/models"
+	"realtime-todos/websockets"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// truncate cuts a client supplied value down to the column size
+func truncate(value string, size int) string {
+	if len(value) > size {
+		return value[:size]
+	}
+	return value
+}
+
+// createSession records a new login of the user from the requesting device
+func createSession(db *gorm.DB, c *fiber.Ctx, user models.User) (models.Session, error) {
+	tokenID, err := helper.NewToken()
+	if err != nil {
+		return models.Session{}, err
+	}
+
+	session := models.Session{
+		UserID:     user.ID,
+		TokenID:    tokenID,
+		UserAgent:  truncate(c.Get(fiber.HeaderUserAgent), 255),
+		IP:         c.IP(),
+		LastUsedAt: time.Now(),
+	}
+
+	return session, db.Create(&session).Error
+}
+
+// revokeSessions logs the sessions out: their refresh tokens and connect
+// tickets stop working, their access tokens are rejected by CheckAuth from now
+// on and their open sockets are closed
+func revokeSessions(db *gorm.DB, sessions []models.Session) error {
+	if len(sessions) == 0 {
+		return nil
+	}
+
+	ids := make([]uint, 0, len(sessions))
+	tokenIDs := make([]string, 0, len(sessions))
+	for _, session := range sessions {
+		ids = append(ids, session.ID)
+		tokenIDs = append(tokenIDs, session.TokenID)
+	}
+
+	now := time.Now()
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.Session{}).Where("id IN ? AND revoked_at IS NULL", ids).Update("revoked_at", now).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Model(&models.RefreshToken{}).Where("family_id IN ? AND revoked_at IS NULL", tokenIDs).Update("revoked_at", now).Error; err != nil {
+			return err
+		}
+
+		// Connect tickets of the sessions must not open new sockets either
+		return tx.Where("session_id IN ?", tokenIDs).Delete(&models.WebSocketTicket{}).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, tokenID := range tokenIDs {
+		middlewares.Revocations.Revoke(tokenID)
+	}
+	websockets.Hub.CloseSessions(tokenIDs)
+	return nil
+}
+
+// activeSessions scopes a query to the user's sessions that are still logged in
+func activeSessions(db *gorm.DB, userID uint) *gorm.DB {
+	return db.Where("user_id = ? AND revoked_at IS NULL AND last_used_at > ?", userID, time.Now().Add(-refreshTokenTTL()))
+}
+
+// GetSessions lists the devices the user is logged in on
+func GetSessions(c *fiber.Ctx) error {
+	db := initialisers.DB
+	username, ok := helper.GetUsername(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Unauthorized",
+		})
+	}
+
+	var user models.User
+	if err := db.Where("username = ?", username).First(&user).Error; err != nil {
+		return helper.HandleError(c, err)
+	}
+
+	var sessions []models.Session
+	if err := activeSessions(db, user.ID).Order("last_used_at DESC").Find(&sessions).Error; err != nil {
+		return helper.HandleError(c, err)
+	}
+
+	type sessionWithCurrent struct {
+		models.Session
+		Current bool `json:"current"`
+	}
+
+	current, _ := c.Locals("sessionID").(string)
+	result := make([]sessionWithCurrent, 0, len(sessions))
+	for _, session := range sessions {
+		result = append(result, sessionWithCurrent{session, session.TokenID == current})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message":  "Sessions fetched successfully",
+		"sessions": result,
+	})
+}
+
+// DeleteSession logs one of the user's sessions out
+func DeleteSession(c *fiber.Ctx) error {
+	db := initialisers.DB
+	username, ok := helper.GetUsername(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Unauthorized",
+		})
+	}
+
+	var user models.User
+	if err := db.Where("username = ?", username).First(&user).Error; err != nil {
+		return helper.HandleError(c, err)
+	}
+
+	sessionID, err := c.ParamsInt("sessionID")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid session ID",
+		})
+	}
+
+	var session models.Session
+	if err := activeSessions(db, user.ID).Where("id = ?", sessionID).First(&session).Error; err != nil {
+		return helper.HandleError(c, err)
+	}
+
+	if err := revokeSessions(db, []models.Session{session}); err != nil {
+		return helper.HandleError(c, err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Session logged out successfully",
+	})
+}
+
+// DeleteSessions logs the user out everywhere, including this session
+func DeleteSessions(c *fiber.Ctx) error {
+	db := initialisers.DB
+	username, ok := helper.GetUsername(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Unauthorized",
+		})
+	}
+
+	var user models.User
+	if err := db.Where("username = ?", username).First(&user).Error; err != nil {
+		return helper.HandleError(c, err)
+	}
+
+	var sessions []models.Session
+	if err := db.Where("user_id = ? AND revoked_at IS NULL", user.ID).Find(&sessions).Error; err != nil {
+		return helper.HandleError(c, err)
+	}
+
+	if err := revokeSessions(db, sessions); err != nil {
+		return helper.HandleError(c, err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Logged out everywhere successfully",
+	})
+}
+
+// Logout logs the current session out
+func Logout(c *fiber.Ctx) error {
+	db := initialisers.DB
+	sessionID, ok := c.Locals("sessionID").(string)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Unauthorized",
+		})
+	}
+
+	var session models.Session
+	if err := db.Where("token_id = ? AND revoked_at IS NULL", sessionID).First(&session).Error; err != nil {
+		return helper.HandleError(c, err)
+	}
+
+	if err := revokeSessions(db, []models.Session{session}); err != nil {
+		return helper.HandleError(c, err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Logged out successfully",
+	})
+}
@@ -0,0 +1,82 @@
+package controllers
+
+import (
+	"realtime-todos/models"
+	"realtime-todos/websockets"
+	"testing"
+	"time"
+)
+
+func newSessionFixture(t *testing.T) (*todoFixture, models.Session) {
+	t.Helper()
+
+	f := newTodoFixture(t)
+	if err := f.db.AutoMigrate(&models.Session{}, &models.RefreshToken{}, &models.WebSocketTicket{}); err != nil {
+		t.Fatalf("migrating: %v", err)
+	}
+
+	session := models.Session{UserID: f.alice.ID, TokenID: "session", LastUsedAt: time.Now()}
+	if err := f.db.Create(&session).Error; err != nil {
+		t.Fatalf("creating session: %v", err)
+	}
+	return f, session
+}
+
+func TestRevokeSessionsDropsConnectTickets(t *testing.T) {
+	f, session := newSessionFixture(t)
+
+	ticket, _, err := websockets.Tickets.Issue(f.alice.ID, "alice", session.TokenID, f.roomA.ID)
+	if err != nil {
+		t.Fatalf("issuing ticket: %v", err)
+	}
+
+	if err := revokeSessions(f.db, []models.Session{session}); err != nil {
+		t.Fatalf("revoking session: %v", err)
+	}
+
+	var tickets int64
+	if err := f.db.Model(&models.WebSocketTicket{}).Count(&tickets).Error; err != nil {
+		t.Fatalf("counting tickets: %v", err)
+	}
+	if tickets != 0 {
+		t.Errorf("got %d tickets left, want 0", tickets)
+	}
+	if _, ok := websockets.Tickets.Consume(ticket, f.roomA.ID); ok {
+		t.Error("ticket of the revoked session was accepted")
+	}
+}
+
+func TestConsumeRejectsTicketOfRevokedSession(t *testing.T) {
+	f, session := newSessionFixture(t)
+
+	ticket, _, err := websockets.Tickets.Issue(f.alice.ID, "alice", session.TokenID, f.roomA.ID)
+	if err != nil {
+		t.Fatalf("issuing ticket: %v", err)
+	}
+
+	// Revoked on another instance after the ticket was issued here
+	if err := f.db.Model(&session).Update("revoked_at", time.Now()).Error; err != nil {
+		t.Fatalf("revoking session: %v", err)
+	}
+
+	if _, ok := websockets.Tickets.Consume(ticket, f.roomA.ID); ok {
+		t.Error("ticket of the revoked session was accepted")
+	}
+}
+
+func TestConsumeAcceptsTicketOfActiveSession(t *testing.T) {
+	f, session := newSessionFixture(t)
+
+	ticket, _, err := websockets.Tickets.Issue(f.alice.ID, "alice", session.TokenID, f.roomA.ID)
+	if err != nil {
+		t.Fatalf("issuing ticket: %v", err)
+	}
+
+	got, ok := websockets.Tickets.Consume(ticket, f.roomA.ID)
+	if !ok {
+		t.Fatal("ticket of the active session was refused")
+	}
+	if got.SessionID != session.TokenID {
+		t.Errorf("got session %q, want %q", got.SessionID, session.TokenID)
+	}
+}
@@ -0,0 +1,107 @@
+package controllers
+
+import (
+	"encoding/json"
+	"realtime-todos/initialisers"
+	"realtime-todos/websockets"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// parseCommandPayload decodes a command payload, reporting bad input the way
+// BodyParser failures are reported over REST
+func parseCommandPayload(payload json.RawMessage, v interface{}) error {
+	if err := json.Unmarshal(payload, v); err != nil {
+		return &websockets.CommandError{Status: fiber.StatusBadRequest, Message: "Failed to parse command payload"}
+	}
+	return nil
+}
+
+func CreateTodoCommand(ctx websockets.CommandContext, payload json.RawMessage) (interface{}, error) {
+	db := initialisers.DB
+
+	user, room, reqErr := loadRoomMember(db, ctx.Username, ctx.RoomID)
+	if reqErr != nil {
+		return nil, reqErr.commandError()
+	}
+
+	var input createTodoInput
+	if err := parseCommandPayload(payload, &input); err != nil {
+		return nil, err
+	}
+
+	todo, reqErr := createTodo(db, user, room, input)
+	if reqErr != nil {
+		return nil, reqErr.commandError()
+	}
+
+	return todo, nil
+}
+
+func UpdateTodoCommand(ctx websockets.CommandContext, payload json.RawMessage) (interface{}, error) {
+	db := initialisers.DB
+
+	user, room, reqErr := loadRoomMember(db, ctx.Username, ctx.RoomID)
+	if reqErr != nil {
+		return nil, reqErr.commandError()
+	}
+
+	var input struct {
+		ID uint `json:"id"`
+		updateTodoInput
+	}
+	if err := parseCommandPayload(payload, &input); err != nil {
+		return nil, err
+	}
+
+	todo, reqErr := updateTodo(db, user, room, input.ID, input.updateTodoInput)
+	if reqErr != nil {
+		return nil, reqErr.commandError()
+	}
+
+	return todo, nil
+}
+
+func DeleteTodoCommand(ctx websockets.CommandContext, payload json.RawMessage) (interface{}, error) {
+	db := initialisers.DB
+
+	user, room, reqErr := loadRoomMember(db, ctx.Username, ctx.RoomID)
+	if reqErr != nil {
+		return nil, reqErr.commandError()
+	}
+
+	var input struct {
+		ID uint `json:"id"`
+	}
+	if err := parseCommandPayload(payload, &input); err != nil {
+		return nil, err
+	}
+
+	if reqErr := deleteTodo(db, user, room, input.ID); reqErr != nil {
+		return nil, reqErr.commandError()
+	}
+
+	return nil, nil
+}
+
+func ReorderTodosCommand(ctx websockets.CommandContext, payload json.RawMessage) (interface{}, error) {
+	db := initialisers.DB
+
+	user, room, reqErr := loadRoomMember(db, ctx.Username, ctx.RoomID)
+	if reqErr != nil {
+		return nil, reqErr.commandError()
+	}
+
+	var input struct {
+		Todos []todoOrder `json:"todos"`
+	}
+	if err := parseCommandPayload(payload, &input); err != nil {
+		return nil, err
+	}
+
+	if reqErr := reorderTodos(db, user, room, input.Todos); reqErr != nil {
+		return nil, reqErr.commandError()
+	}
+
+	return nil, nil
+}
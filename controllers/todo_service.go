@@ -0,0 +1,221 @@
+package controllers
+
+import (
+	"errors"
+	"realtime-todos/helper"
+	"realtime-todos/models"
+	"realtime-todos/websockets"
+	"sort"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// The todo operations below are shared by the REST handlers and the WebSocket
+// commands, so both go through the same validation, authorization and broadcasts.
+
+// requestError is a failure that can be reported back to the client as is
+type requestError struct {
+	status  int
+	message string
+}
+
+func (e *requestError) Error() string {
+	return e.message
+}
+
+func (e *requestError) send(c *fiber.Ctx) error {
+	return c.Status(e.status).JSON(fiber.Map{
+		"error": e.message,
+	})
+}
+
+// commandError converts the error for a WebSocket command reply
+func (e *requestError) commandError() error {
+	return &websockets.CommandError{Status: e.status, Message: e.message}
+}
+
+// dbError maps a gorm error the same way helper.HandleError does
+func dbError(err error) *requestError {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return &requestError{fiber.StatusNotFound, "Record not found"}
+	}
+	return &requestError{fiber.StatusInternalServerError, "Internal server error"}
+}
+
+// loadRoomMember loads the user and the room, making sure the user is a member
+func loadRoomMember(db *gorm.DB, username string, roomID uint) (models.User, models.Room, *requestError) {
+	var user models.User
+	if err := db.Where("username = ?", username).First(&user).Error; err != nil {
+		return user, models.Room{}, dbError(err)
+	}
+
+	var room models.Room
+	if err := db.Preload("Users").Preload("Members").Where("id = ?", roomID).First(&room).Error; err != nil {
+		return user, room, dbError(err)
+	}
+
+	if !helper.IsUserInRoom(user, room) {
+		return user, room, &requestError{fiber.StatusUnauthorized, "Unauthorized"}
+	}
+
+	return user, room, nil
+}
+
+// forbidden is returned when the member's role does not grant the operation
+var forbidden = &requestError{fiber.StatusForbidden, "Forbidden"}
+
+type createTodoInput struct {
+	Title string `json:"title"`
+	Order uint   `json:"order"`
+}
+
+func createTodo(db *gorm.DB, user models.User, room models.Room, input createTodoInput) (models.Todo, *requestError) {
+	if !helper.CanInRoom(user, room, helper.PermissionEditTodos) {
+		return models.Todo{}, forbidden
+	}
+
+	if input.Title == "" {
+		return models.Todo{}, &requestError{fiber.StatusBadRequest, "Title is required"}
+	}
+
+	var todo = models.Todo{
+		RoomID:      room.ID,
+		UserID:      user.ID,
+		Title:       input.Title,
+		IsCompleted: false,
+		Order:       input.Order,
+	}
+
+	if err := db.Create(&todo).Error; err != nil {
+		return todo, &requestError{fiber.StatusInternalServerError, "Error creating the todo"}
+	}
+
+	websockets.BroadcastTodoCreated(room, todo, user.Username)
+	return todo, nil
+}
+
+type updateTodoInput struct {
+	Title       *string `json:"title"`       // Make pointer to handle optional fields
+	IsCompleted *bool   `json:"isCompleted"` // Make pointer to handle optional fields
+	Order       *uint   `json:"order"`       // Make pointer to handle optional fields
+}
+
+func updateTodo(db *gorm.DB, user models.User, room models.Room, todoID uint, input updateTodoInput) (models.Todo, *requestError) {
+	if !helper.CanInRoom(user, room, helper.PermissionEditTodos) {
+		return models.Todo{}, forbidden
+	}
+
+	todo, reqErr := findRoomTodo(db, room.ID, todoID)
+	if reqErr != nil {
+		return todo, reqErr
+	}
+
+	// Respect the editing lock another user took through start_editing
+	if holder, ok := websockets.Hub.EditingLock(room.ID, todo.ID); ok && holder.UserID != user.ID {
+		return todo, &requestError{fiber.StatusConflict, "Todo is being edited by " + holder.Username}
+	}
+
+	// Track the changed fields so the broadcast only carries what changed
+	changes := map[string]interface{}{}
+
+	if input.Title != nil {
+		if *input.Title == "" {
+			return todo, &requestError{fiber.StatusBadRequest, "Title cannot be empty"}
+		}
+		todo.Title = *input.Title
+		changes["title"] = todo.Title
+	}
+
+	if input.IsCompleted != nil {
+		todo.IsCompleted = *input.IsCompleted
+		changes["isCompleted"] = todo.IsCompleted
+	}
+
+	if input.Order != nil {
+		todo.Order = *input.Order
+		changes["order"] = todo.Order
+	}
+
+	if err := db.Save(&todo).Error; err != nil {
+		return todo, &requestError{fiber.StatusInternalServerError, "Error saving the todo"}
+	}
+
+	websockets.BroadcastTodoUpdated(room.ID, todo.ID, changes)
+	return todo, nil
+}
+
+func deleteTodo(db *gorm.DB, user models.User, room models.Room, todoID uint) *requestError {
+	if !helper.CanInRoom(user, room, helper.PermissionEditTodos) {
+		return forbidden
+	}
+
+	todo, reqErr := findRoomTodo(db, room.ID, todoID)
+	if reqErr != nil {
+		return reqErr
+	}
+
+	// Editors may only delete their own todos
+	if todo.UserID != user.ID && !helper.CanInRoom(user, room, helper.PermissionManageTodos) {
+		return forbidden
+	}
+
+	if err := db.Delete(&todo).Error; err != nil {
+		return &requestError{fiber.StatusInternalServerError, "Error deleting the todo"}
+	}
+
+	websockets.BroadcastTodoDeleted(room.ID, todo.ID)
+	return nil
+}
+
+// todoOrder matches the frontend data structure for reordering
+type todoOrder struct {
+	ID    uint `json:"id"`
+	Order uint `json:"order"`
+}
+
+func reorderTodos(db *gorm.DB, user models.User, room models.Room, todos []todoOrder) *requestError {
+	if !helper.CanInRoom(user, room, helper.PermissionEditTodos) {
+		return forbidden
+	}
+
+	ids := make([]uint, 0, len(todos))
+	for _, update := range todos {
+		ids = append(ids, update.ID)
+	}
+
+	// Reject the whole reorder if any ID is not a todo of this room
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if reqErr := checkRoomTodos(tx, room.ID, ids); reqErr != nil {
+			return reqErr
+		}
+
+		// Update the order of each todo in the database
+		for _, update := range todos {
+			if err := roomTodos(tx, room.ID).Where("id = ?", update.ID).Update("order", update.Order).Error; err != nil {
+				return dbError(err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		var reqErr *requestError
+		if errors.As(err, &reqErr) {
+			return reqErr
+		}
+		return dbError(err)
+	}
+
+	sorted := append([]todoOrder(nil), todos...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Order < sorted[j].Order
+	})
+
+	ids = ids[:0]
+	for _, update := range sorted {
+		ids = append(ids, update.ID)
+	}
+
+	websockets.BroadcastTodosReordered(room.ID, ids)
+	return nil
+}
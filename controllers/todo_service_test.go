@@ -0,0 +1,190 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"realtime-todos/websockets"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Cross-room access through the service functions shared by REST and the
+// WebSocket commands: a todo ID of another room behaves like a missing one
+// and nothing is written.
+
+func TestUpdateTodoInOtherRoom(t *testing.T) {
+	f := newTodoFixture(t)
+	user, room := f.member(t, "alice", f.roomA)
+
+	title := "Renamed"
+	completed := true
+	_, reqErr := updateTodo(f.db, user, room, f.todoB.ID, updateTodoInput{Title: &title, IsCompleted: &completed})
+	if reqErr != todoNotFound {
+		t.Fatalf("got %v, want todoNotFound", reqErr)
+	}
+
+	f.assertUntouched(t, f.todoB)
+}
+
+func TestDeleteTodoInOtherRoom(t *testing.T) {
+	f := newTodoFixture(t)
+	user, room := f.member(t, "alice", f.roomA)
+
+	if reqErr := deleteTodo(f.db, user, room, f.todoB.ID); reqErr != todoNotFound {
+		t.Fatalf("got %v, want todoNotFound", reqErr)
+	}
+
+	f.assertUntouched(t, f.todoB)
+}
+
+func TestReorderTodosWithForeignID(t *testing.T) {
+	f := newTodoFixture(t)
+	user, room := f.member(t, "alice", f.roomA)
+
+	reqErr := reorderTodos(f.db, user, room, []todoOrder{
+		{ID: f.todoA.ID, Order: 5},
+		{ID: f.todoB.ID, Order: 7},
+	})
+	if reqErr != todoNotFound {
+		t.Fatalf("got %v, want todoNotFound", reqErr)
+	}
+
+	// The whole reorder is rejected, including the todo of the member's own room
+	f.assertUntouched(t, f.todoA)
+	f.assertUntouched(t, f.todoB)
+}
+
+func TestReorderTodosInOwnRoom(t *testing.T) {
+	f := newTodoFixture(t)
+	user, room := f.member(t, "alice", f.roomA)
+
+	if reqErr := reorderTodos(f.db, user, room, []todoOrder{{ID: f.todoA.ID, Order: 5}}); reqErr != nil {
+		t.Fatalf("reordering own todo: %v", reqErr)
+	}
+
+	if got := f.reload(t, f.todoA.ID).Order; got != 5 {
+		t.Errorf("got order %d, want 5", got)
+	}
+}
+
+// todoApp serves the todo routes as the room router does, authenticated as username
+func todoApp(username string) *fiber.App {
+	app := fiber.New()
+	app.Use(func(c *fiber.Ctx) error {
+		c.Locals("username", username)
+		return c.Next()
+	})
+	app.Patch("/api/room/:roomID/todo/:todoID", UpdateTodo)
+	app.Delete("/api/room/:roomID/todo/:todoID", RemoveTodo)
+	app.Patch("/api/room/:roomID/todos", ReorderTodos)
+	return app
+}
+
+func TestTodoRoutesRejectOtherRoomTodos(t *testing.T) {
+	f := newTodoFixture(t)
+	app := todoApp("alice")
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+	}{
+		{
+			name:   "update",
+			method: http.MethodPatch,
+			path:   fmt.Sprintf("/api/room/%d/todo/%d", f.roomA.ID, f.todoB.ID),
+			body:   `{"title":"Renamed","isCompleted":true}`,
+		},
+		{
+			name:   "delete",
+			method: http.MethodDelete,
+			path:   fmt.Sprintf("/api/room/%d/todo/%d", f.roomA.ID, f.todoB.ID),
+		},
+		{
+			name:   "reorder",
+			method: http.MethodPatch,
+			path:   fmt.Sprintf("/api/room/%d/todos", f.roomA.ID),
+			body:   fmt.Sprintf(`{"todos":[{"id":%d,"order":5},{"id":%d,"order":7}]}`, f.todoA.ID, f.todoB.ID),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("sending request: %v", err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != fiber.StatusNotFound {
+				t.Errorf("got status %d, want %d", resp.StatusCode, fiber.StatusNotFound)
+			}
+
+			var body struct {
+				Error string `json:"error"`
+			}
+			if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+				t.Fatalf("decoding response: %v", err)
+			}
+			if body.Error != todoNotFound.message {
+				t.Errorf("got error %q, want %q", body.Error, todoNotFound.message)
+			}
+
+			f.assertUntouched(t, f.todoA)
+			f.assertUntouched(t, f.todoB)
+		})
+	}
+}
+
+func TestTodoCommandsRejectOtherRoomTodos(t *testing.T) {
+	f := newTodoFixture(t)
+	ctx := websockets.CommandContext{UserID: f.alice.ID, Username: "alice", RoomID: f.roomA.ID}
+
+	tests := []struct {
+		name    string
+		handler websockets.CommandHandler
+		payload string
+	}{
+		{
+			name:    "update_todo",
+			handler: UpdateTodoCommand,
+			payload: fmt.Sprintf(`{"id":%d,"title":"Renamed","isCompleted":true}`, f.todoB.ID),
+		},
+		{
+			name:    "delete_todo",
+			handler: DeleteTodoCommand,
+			payload: fmt.Sprintf(`{"id":%d}`, f.todoB.ID),
+		},
+		{
+			name:    "reorder",
+			handler: ReorderTodosCommand,
+			payload: fmt.Sprintf(`{"todos":[{"id":%d,"order":5},{"id":%d,"order":7}]}`, f.todoA.ID, f.todoB.ID),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.handler(ctx, json.RawMessage(tt.payload))
+
+			var commandErr *websockets.CommandError
+			if !errors.As(err, &commandErr) {
+				t.Fatalf("got %v, want a CommandError", err)
+			}
+			if commandErr.Status != fiber.StatusNotFound {
+				t.Errorf("got status %d, want %d", commandErr.Status, fiber.StatusNotFound)
+			}
+
+			f.assertUntouched(t, f.todoA)
+			f.assertUntouched(t, f.todoB)
+		})
+	}
+}
@@ -0,0 +1,156 @@
+package controllers
+
+import (
+	"log"
+	"realtime-todos/helper"
+	"realtime-todos/initialisers"
+	"realtime-todos/middlewares"
+	"realtime-todos/models"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// Login hands out a short-lived access token (the "jwt") together with a
+// refresh token. Each refresh token can be used once, and using it returns a
+// new pair whose refresh token joins the same family, the session. A refresh
+// token used a second time means it leaked, so the whole session is revoked
+// and the user has to log in again.
+
+// refreshTokenTTL is how long a refresh token can be used, REFRESH_TOKEN_TTL
+func refreshTokenTTL() time.Duration {
+	return helper.DurationFromEnv("REFRESH_TOKEN_TTL", 30*24*time.Hour)
+}
+
+// issueRefreshToken stores a new refresh token for the user in the family,
+// which is the TokenID of the session it belongs to
+func issueRefreshToken(db *gorm.DB, userID uint, familyID string) (string, error) {
+	token, err := helper.NewToken()
+	if err != nil {
+		return "", err
+	}
+
+	refreshToken := models.RefreshToken{
+		UserID:    userID,
+		FamilyID:  familyID,
+		TokenHash: helper.HashToken(token),
+		ExpiresAt: time.Now().Add(refreshTokenTTL()),
+	}
+
+	if err := db.Create(&refreshToken).Error; err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// tokenResponse is the body Login and RefreshToken answer with
+func tokenResponse(message string, accessToken string, refreshToken string) fiber.Map {
+	return fiber.Map{
+		"message":      message,
+		"jwt":          accessToken,
+		"refreshToken": refreshToken,
+		"expiresIn":    int(middlewares.AccessTokenTTL().Seconds()),
+	}
+}
+
+// RefreshToken exchanges a refresh token for a new access and refresh token
+func RefreshToken(c *fiber.Ctx) error {
+	db := initialisers.DB
+
+	var body struct {
+		RefreshToken string `json:"refreshToken"`
+	}
+
+	if err := c.BodyParser(&body); err != nil || body.RefreshToken == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Refresh token is required",
+		})
+	}
+
+	invalid := func(body fiber.Map) error {
+		return c.Status(fiber.StatusUnauthorized).JSON(body)
+	}
+	invalidBody := fiber.Map{
+		"error": "Invalid refresh token",
+		"code":  "invalid_refresh_token",
+	}
+
+	var stored models.RefreshToken
+	if err := db.Where("token_hash = ?", helper.HashToken(body.RefreshToken)).First(&stored).Error; err != nil {
+		return invalid(invalidBody)
+	}
+
+	if stored.RevokedAt != nil {
+		return invalid(invalidBody)
+	}
+
+	if time.Now().After(stored.ExpiresAt) {
+		return invalid(fiber.Map{
+			"error": "Refresh token expired",
+			"code":  "refresh_token_expired",
+		})
+	}
+
+	var user models.User
+	if err := db.Where("id = ?", stored.UserID).First(&user).Error; err != nil {
+		return invalid(invalidBody)
+	}
+
+	var session models.Session
+	if err := db.Where("token_id = ? AND revoked_at IS NULL", stored.FamilyID).First(&session).Error; err != nil {
+		return invalid(invalidBody)
+	}
+
+	var refreshToken string
+	reused := false
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// Marking the token used only succeeds once, a concurrent or later
+		// use of the same token is a replay
+		result := tx.Model(&stored).Where("used_at IS NULL").Update("used_at", time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			reused = true
+			return nil
+		}
+
+		if err := tx.Model(&session).Updates(map[string]interface{}{
+			"last_used_at": time.Now(),
+			"ip":           c.IP(),
+			"user_agent":   truncate(c.Get(fiber.HeaderUserAgent), 255),
+		}).Error; err != nil {
+			return err
+		}
+
+		var err error
+		refreshToken, err = issueRefreshToken(tx, user.ID, session.TokenID)
+		return err
+	})
+	if err != nil {
+		return helper.HandleError(c, err)
+	}
+
+	if reused {
+		log.Printf("Refresh token reuse for user %d, revoking session %d", user.ID, session.ID)
+		if err := revokeSessions(db, []models.Session{session}); err != nil {
+			return helper.HandleError(c, err)
+		}
+		return invalid(fiber.Map{
+			"error": "Refresh token has already been used",
+			"code":  "refresh_token_reused",
+		})
+	}
+
+	accessToken, err := generateJWT(user.Username, session.TokenID)
+	if err != nil {
+		log.Println("Something went wrong while generating JWT token")
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Something went wrong",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(tokenResponse("Token refreshed successfully", accessToken, refreshToken))
+}
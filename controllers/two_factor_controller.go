@@ -0,0 +1,529 @@
+package controllers
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"os"
+	"realtime-todos/helper"
+	"realtime-todos/initialisers"
+	"realtime-todos/middlewares"
+	"realtime-todos/models"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
+)
+
+// recoveryCodeCount is how many recovery codes a user gets at a time
+const recoveryCodeCount = 10
+
+// maxMFAAttempts is how many codes one MFA challenge accepts before it stops
+// working and the password has to be entered again
+const maxMFAAttempts = 5
+
+// maxMFAUserFailures is how many wrong codes in a row lock the account's
+// second factor for mfaLockout, however many challenges they are spread over
+const maxMFAUserFailures = 10
+
+// mfaLockout is how long the second factor stays locked after too many wrong
+// codes, MFA_LOCKOUT
+func mfaLockout() time.Duration {
+	return helper.DurationFromEnv("MFA_LOCKOUT", 15*time.Minute)
+}
+
+// mfaChallengeTTL is how long the password step of a login stays valid,
+// MFA_CHALLENGE_TTL
+func mfaChallengeTTL() time.Duration {
+	return helper.DurationFromEnv("MFA_CHALLENGE_TTL", 5*time.Minute)
+}
+
+// totpIssuer is the name authenticator apps show for the account, TOTP_ISSUER
+func totpIssuer() string {
+	if issuer := os.Getenv("TOTP_ISSUER"); issuer != "" {
+		return issuer
+	}
+	return "Realtime Todos"
+}
+
+// generateMFAChallenge signs a token proving the user passed the password
+// step and records it to count the codes tried with it. Its typ keeps it from
+// being accepted as an access token.
+func generateMFAChallenge(db *gorm.DB, username string) (string, error) {
+	jti, err := helper.NewToken()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	if err := db.Where("expires_at < ?", now).Delete(&models.MFAChallenge{}).Error; err != nil {
+		return "", err
+	}
+	if err := db.Create(&models.MFAChallenge{JTI: jti, ExpiresAt: now.Add(mfaChallengeTTL())}).Error; err != nil {
+		return "", err
+	}
+
+	return middlewares.Keys.Sign(jwt.MapClaims{
+		"username": username,
+		"typ":      "mfa",
+		"jti":      jti,
+		"exp":      now.Add(mfaChallengeTTL()).Unix(),
+		"iat":      now.Unix(),
+	})
+}
+
+// parseMFAChallenge validates a challenge token and returns its username and jti
+func parseMFAChallenge(tokenString string) (string, string, error) {
+	token, err := jwt.Parse(tokenString, middlewares.Keys.Keyfunc, jwt.WithExpirationRequired())
+	if err != nil {
+		return "", "", err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return "", "", fmt.Errorf("invalid token")
+	}
+
+	if typ, _ := claims["typ"].(string); typ != "mfa" {
+		return "", "", fmt.Errorf("not an MFA challenge")
+	}
+
+	username, _ := claims["username"].(string)
+	jti, _ := claims["jti"].(string)
+	if username == "" || jti == "" {
+		return "", "", fmt.Errorf("invalid token claims")
+	}
+
+	return username, jti, nil
+}
+
+// reserveMFAChallengeAttempt uses up one of the challenge's attempts before
+// the code is checked, so concurrent guesses cannot all slip in under the
+// limit. It reports false when the challenge is unknown, used or exhausted.
+func reserveMFAChallengeAttempt(db *gorm.DB, jti string) (bool, error) {
+	result := db.Model(&models.MFAChallenge{}).
+		Where("jti = ? AND attempts < ? AND expires_at > ?", jti, maxMFAAttempts, time.Now()).
+		Update("attempts", gorm.Expr("attempts + 1"))
+	return result.RowsAffected > 0, result.Error
+}
+
+// reserveMFAUserAttempt counts an attempt against the user before the code is
+// checked and reports false while the second factor is locked. The count
+// starts over once mfaLockout has passed since the last attempt.
+func reserveMFAUserAttempt(db *gorm.DB, userID uint) (bool, error) {
+	now := time.Now()
+	expired := now.Add(-mfaLockout())
+
+	result := db.Model(&models.User{}).
+		Where("id = ? AND (mfa_failures < ? OR mfa_failed_at IS NULL OR mfa_failed_at < ?)", userID, maxMFAUserFailures, expired).
+		Updates(map[string]interface{}{
+			"mfa_failures":  gorm.Expr("CASE WHEN mfa_failed_at IS NULL OR mfa_failed_at < ? THEN 1 ELSE mfa_failures + 1 END", expired),
+			"mfa_failed_at": now,
+		})
+	return result.RowsAffected > 0, result.Error
+}
+
+// passMFAChallenge ends the challenge after a correct code and clears the
+// user's count of wrong ones
+func passMFAChallenge(db *gorm.DB, jti string, userID uint) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("jti = ?", jti).Delete(&models.MFAChallenge{}).Error; err != nil {
+			return err
+		}
+
+		return tx.Model(&models.User{}).Where("id = ?", userID).Update("mfa_failures", 0).Error
+	})
+}
+
+// normalizeRecoveryCode ignores case, spaces and dashes in recovery codes
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+// newRecoveryCodes replaces the user's recovery codes and returns the new
+// ones, which are only ever shown this once
+func newRecoveryCodes(tx *gorm.DB, userID uint) ([]string, error) {
+	const alphabet = "abcdefghijkmnpqrstuvwxyz23456789"
+
+	codes := make([]string, 0, recoveryCodeCount)
+	records := make([]models.RecoveryCode, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		buf := make([]byte, 10)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, err
+		}
+		for j := range buf {
+			buf[j] = alphabet[int(buf[j])%len(alphabet)]
+		}
+
+		code := string(buf[:5]) + "-" + string(buf[5:])
+		codes = append(codes, code)
+		records = append(records, models.RecoveryCode{
+			UserID:   userID,
+			CodeHash: helper.HashToken(normalizeRecoveryCode(code)),
+		})
+	}
+
+	if err := tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Create(&records).Error; err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// verifyTOTP checks a code from the authenticator app. Each code is accepted
+// only once, even by concurrent requests.
+func verifyTOTP(db *gorm.DB, user *models.User, code string) (bool, error) {
+	if user.TOTPSecret == nil {
+		return false, nil
+	}
+
+	step, ok := helper.ValidateTOTP(*user.TOTPSecret, strings.TrimSpace(code), time.Now())
+	if !ok || step <= user.TOTPLastStep {
+		return false, nil
+	}
+
+	result := db.Model(&models.User{}).
+		Where("id = ? AND totp_last_step < ?", user.ID, step).
+		Update("totp_last_step", step)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return false, nil
+	}
+
+	user.TOTPLastStep = step
+	return true, nil
+}
+
+// verifySecondFactor accepts either a TOTP code or an unused recovery code,
+// which is used up
+func verifySecondFactor(db *gorm.DB, user *models.User, code string) (bool, error) {
+	if ok, err := verifyTOTP(db, user, code); ok || err != nil {
+		return ok, err
+	}
+
+	normalized := normalizeRecoveryCode(code)
+	if normalized == "" {
+		return false, nil
+	}
+
+	result := db.Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", user.ID, helper.HashToken(normalized)).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// LoginTwoFactor exchanges the MFA challenge from Login and a TOTP or
+// recovery code for an access token
+func LoginTwoFactor(c *fiber.Ctx) error {
+	db := initialisers.DB
+
+	var body struct {
+		MFAToken string `json:"mfaToken"`
+		Code     string `json:"code"`
+	}
+
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Failed to parse request body",
+		})
+	}
+
+	username, jti, err := parseMFAChallenge(body.MFAToken)
+	if errors.Is(err, jwt.ErrTokenExpired) {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Login expired, enter your password again",
+			"code":  "mfa_expired",
+		})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid login, enter your password again",
+			"code":  "invalid_mfa_token",
+		})
+	}
+
+	reserved, err := reserveMFAChallengeAttempt(db, jti)
+	if err != nil {
+		return helper.HandleError(c, err)
+	}
+	if !reserved {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid login, enter your password again",
+			"code":  "invalid_mfa_token",
+		})
+	}
+
+	var user models.User
+	if err := db.Where("username = ?", username).First(&user).Error; err != nil || !user.TOTPEnabled {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid login, enter your password again",
+			"code":  "invalid_mfa_token",
+		})
+	}
+
+	reserved, err = reserveMFAUserAttempt(db, user.ID)
+	if err != nil {
+		return helper.HandleError(c, err)
+	}
+	if !reserved {
+		return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+			"error": "Too many wrong codes, try again later",
+			"code":  "mfa_locked",
+		})
+	}
+
+	ok, err := verifySecondFactor(db, &user, body.Code)
+	if err != nil {
+		return helper.HandleError(c, err)
+	}
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid code",
+			"code":  "invalid_code",
+		})
+	}
+
+	if err := passMFAChallenge(db, jti, user.ID); err != nil {
+		return helper.HandleError(c, err)
+	}
+
+	return completeLogin(c, db, user)
+}
+
+// SetupTwoFactor starts enrollment with a new secret for the authenticator
+// app. Two-factor authentication is only enabled once EnableTwoFactor
+// verifies a code generated from it.
+func SetupTwoFactor(c *fiber.Ctx) error {
+	db := initialisers.DB
+	username, ok := helper.GetUsername(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Unauthorized",
+		})
+	}
+
+	var user models.User
+	if err := db.Where("username = ?", username).First(&user).Error; err != nil {
+		return helper.HandleError(c, err)
+	}
+
+	if user.TOTPEnabled {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Two-factor authentication is already enabled",
+		})
+	}
+
+	secret, err := helper.NewTOTPSecret()
+	if err != nil {
+		return helper.HandleError(c, err)
+	}
+
+	if err := db.Model(&user).Updates(map[string]interface{}{"totp_secret": secret, "totp_last_step": 0}).Error; err != nil {
+		return helper.HandleError(c, err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message":    "Scan the code with your authenticator app, then confirm a code to enable two-factor authentication",
+		"secret":     secret,
+		"otpauthUri": helper.TOTPURI(totpIssuer(), user.Username, secret),
+	})
+}
+
+// EnableTwoFactor verifies a first code from the authenticator app, enables
+// two-factor authentication and returns the recovery codes
+func EnableTwoFactor(c *fiber.Ctx) error {
+	db := initialisers.DB
+	username, ok := helper.GetUsername(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Unauthorized",
+		})
+	}
+
+	var body struct {
+		Code string `json:"code"`
+	}
+
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Failed to parse request body",
+		})
+	}
+
+	var user models.User
+	if err := db.Where("username = ?", username).First(&user).Error; err != nil {
+		return helper.HandleError(c, err)
+	}
+
+	if user.TOTPEnabled {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Two-factor authentication is already enabled",
+		})
+	}
+	if user.TOTPSecret == nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Set up two-factor authentication first",
+		})
+	}
+
+	valid, err := verifyTOTP(db, &user, body.Code)
+	if err != nil {
+		return helper.HandleError(c, err)
+	}
+	if !valid {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid code",
+		})
+	}
+
+	var codes []string
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Update("totp_enabled", true).Error; err != nil {
+			return err
+		}
+
+		codes, err = newRecoveryCodes(tx, user.ID)
+		return err
+	})
+	if err != nil {
+		return helper.HandleError(c, err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message":       "Two-factor authentication enabled, store the recovery codes somewhere safe",
+		"recoveryCodes": codes,
+	})
+}
+
+// DisableTwoFactor turns two-factor authentication off after confirming the
+// password and a TOTP or recovery code
+func DisableTwoFactor(c *fiber.Ctx) error {
+	db := initialisers.DB
+	username, ok := helper.GetUsername(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Unauthorized",
+		})
+	}
+
+	var body struct {
+		Password string `json:"password"`
+		Code     string `json:"code"`
+	}
+
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Failed to parse request body",
+		})
+	}
+
+	var user models.User
+	if err := db.Where("username = ?", username).First(&user).Error; err != nil {
+		return helper.HandleError(c, err)
+	}
+
+	if !user.TOTPEnabled {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Two-factor authentication is not enabled",
+		})
+	}
+
+	if !verifyPassword(body.Password, user.Password) {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid password",
+		})
+	}
+
+	valid, err := verifySecondFactor(db, &user, body.Code)
+	if err != nil {
+		return helper.HandleError(c, err)
+	}
+	if !valid {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid code",
+		})
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Updates(map[string]interface{}{"totp_enabled": false, "totp_secret": nil, "totp_last_step": 0}).Error; err != nil {
+			return err
+		}
+
+		return tx.Where("user_id = ?", user.ID).Delete(&models.RecoveryCode{}).Error
+	})
+	if err != nil {
+		return helper.HandleError(c, err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Two-factor authentication disabled",
+	})
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes after confirming a
+// TOTP code, for when they were used up or exposed
+func RegenerateRecoveryCodes(c *fiber.Ctx) error {
+	db := initialisers.DB
+	username, ok := helper.GetUsername(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Unauthorized",
+		})
+	}
+
+	var body struct {
+		Code string `json:"code"`
+	}
+
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Failed to parse request body",
+		})
+	}
+
+	var user models.User
+	if err := db.Where("username = ?", username).First(&user).Error; err != nil {
+		return helper.HandleError(c, err)
+	}
+
+	if !user.TOTPEnabled {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Two-factor authentication is not enabled",
+		})
+	}
+
+	valid, err := verifyTOTP(db, &user, body.Code)
+	if err != nil {
+		return helper.HandleError(c, err)
+	}
+	if !valid {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid code",
+		})
+	}
+
+	var codes []string
+	err = db.Transaction(func(tx *gorm.DB) error {
+		codes, err = newRecoveryCodes(tx, user.ID)
+		return err
+	})
+	if err != nil {
+		return helper.HandleError(c, err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message":       "Recovery codes regenerated",
+		"recoveryCodes": codes,
+	})
+}
@@ -0,0 +1,119 @@
+package controllers
+
+import (
+	"realtime-todos/models"
+	"testing"
+	"time"
+)
+
+func newMFAFixture(t *testing.T) *todoFixture {
+	t.Helper()
+
+	f := newTodoFixture(t)
+	if err := f.db.AutoMigrate(&models.MFAChallenge{}); err != nil {
+		t.Fatalf("migrating: %v", err)
+	}
+	return f
+}
+
+// createMFAChallenge records a challenge the way generateMFAChallenge does
+func (f *todoFixture) createMFAChallenge(t *testing.T) models.MFAChallenge {
+	t.Helper()
+
+	challenge := models.MFAChallenge{JTI: "challenge", ExpiresAt: time.Now().Add(mfaChallengeTTL())}
+	if err := f.db.Create(&challenge).Error; err != nil {
+		t.Fatalf("creating challenge: %v", err)
+	}
+	return challenge
+}
+
+func TestMFAChallengeAttemptsAreCapped(t *testing.T) {
+	f := newMFAFixture(t)
+
+	challenge := f.createMFAChallenge(t)
+
+	for i := 0; i < maxMFAAttempts; i++ {
+		if ok, err := reserveMFAChallengeAttempt(f.db, challenge.JTI); !ok || err != nil {
+			t.Fatalf("attempt %d: got %v, %v, want it reserved", i+1, ok, err)
+		}
+	}
+	if ok, err := reserveMFAChallengeAttempt(f.db, challenge.JTI); ok || err != nil {
+		t.Errorf("attempt past the limit: got %v, %v, want it refused", ok, err)
+	}
+
+	if ok, err := reserveMFAChallengeAttempt(f.db, "unknown"); ok || err != nil {
+		t.Errorf("unknown challenge: got %v, %v, want it refused", ok, err)
+	}
+}
+
+func TestMFAChallengeCannotBeReusedAfterLogin(t *testing.T) {
+	f := newMFAFixture(t)
+
+	challenge := f.createMFAChallenge(t)
+
+	if ok, err := reserveMFAChallengeAttempt(f.db, challenge.JTI); !ok || err != nil {
+		t.Fatalf("got %v, %v, want the attempt reserved", ok, err)
+	}
+	if err := passMFAChallenge(f.db, challenge.JTI, f.alice.ID); err != nil {
+		t.Fatalf("passing challenge: %v", err)
+	}
+	if ok, err := reserveMFAChallengeAttempt(f.db, challenge.JTI); ok || err != nil {
+		t.Errorf("used challenge: got %v, %v, want it refused", ok, err)
+	}
+}
+
+func TestMFAUserLockoutSpansChallenges(t *testing.T) {
+	f := newMFAFixture(t)
+
+	for i := 0; i < maxMFAUserFailures; i++ {
+		if ok, err := reserveMFAUserAttempt(f.db, f.alice.ID); !ok || err != nil {
+			t.Fatalf("attempt %d: got %v, %v, want it reserved", i+1, ok, err)
+		}
+	}
+	if ok, err := reserveMFAUserAttempt(f.db, f.alice.ID); ok || err != nil {
+		t.Fatalf("attempt past the limit: got %v, %v, want the user locked", ok, err)
+	}
+
+	// Other users are not affected
+	if ok, err := reserveMFAUserAttempt(f.db, f.bob.ID); !ok || err != nil {
+		t.Errorf("other user: got %v, %v, want the attempt reserved", ok, err)
+	}
+
+	// The lock lifts once the lockout has passed since the last attempt
+	expired := time.Now().Add(-2 * mfaLockout())
+	if err := f.db.Model(&models.User{}).Where("id = ?", f.alice.ID).Update("mfa_failed_at", expired).Error; err != nil {
+		t.Fatalf("backdating attempts: %v", err)
+	}
+	if ok, err := reserveMFAUserAttempt(f.db, f.alice.ID); !ok || err != nil {
+		t.Fatalf("after the lockout: got %v, %v, want the attempt reserved", ok, err)
+	}
+	if got := f.reloadUser(t, f.alice.ID).MFAFailures; got != 1 {
+		t.Errorf("got %d failures after the lockout, want 1", got)
+	}
+}
+
+func TestPassMFAChallengeClearsUserFailures(t *testing.T) {
+	f := newMFAFixture(t)
+
+	for i := 0; i < maxMFAUserFailures; i++ {
+		if _, err := reserveMFAUserAttempt(f.db, f.alice.ID); err != nil {
+			t.Fatalf("reserving attempt: %v", err)
+		}
+	}
+	if err := passMFAChallenge(f.db, "unused", f.alice.ID); err != nil {
+		t.Fatalf("passing challenge: %v", err)
+	}
+	if ok, err := reserveMFAUserAttempt(f.db, f.alice.ID); !ok || err != nil {
+		t.Errorf("got %v, %v, want the attempt reserved", ok, err)
+	}
+}
+
+func (f *todoFixture) reloadUser(t *testing.T, id uint) models.User {
+	t.Helper()
+
+	var user models.User
+	if err := f.db.First(&user, id).Error; err != nil {
+		t.Fatalf("reloading user %d: %v", id, err)
+	}
+	return user
+}
@@ -0,0 +1,84 @@
+package controllers
+
+import (
+	"realtime-todos/helper"
+	"realtime-todos/initialisers"
+	"realtime-todos/models"
+	"realtime-todos/websockets"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// IssueWebSocketTicket mints a short-lived, single-use ticket the client passes
+// as ?ticket= when opening /ws/:roomID, so the JWT never ends up in a URL
+func IssueWebSocketTicket(c *fiber.Ctx) error {
+	db := initialisers.DB
+	username, ok := helper.GetUsername(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Unauthorized",
+		})
+	}
+
+	var user models.User
+	if err := db.Where("username = ?", username).First(&user).Error; err != nil {
+		return helper.HandleError(c, err)
+	}
+
+	roomID := c.Params("roomID")
+
+	var room models.Room
+	if err := db.Preload("Users").Where("id = ?", roomID).First(&room).Error; err != nil {
+		return helper.HandleError(c, err)
+	}
+
+	if !helper.IsUserInRoom(user, room) {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Unauthorized",
+		})
+	}
+
+	sessionID, _ := c.Locals("sessionID").(string)
+	ticket, expiresAt, err := websockets.Tickets.Issue(user.ID, user.Username, sessionID, room.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Error issuing the ticket",
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"message":   "Ticket issued successfully",
+		"ticket":    ticket,
+		"expiresAt": expiresAt,
+	})
+}
+
+// IssueUserWebSocketTicket mints a ticket for the /ws/user notification channel
+func IssueUserWebSocketTicket(c *fiber.Ctx) error {
+	db := initialisers.DB
+	username, ok := helper.GetUsername(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Unauthorized",
+		})
+	}
+
+	var user models.User
+	if err := db.Where("username = ?", username).First(&user).Error; err != nil {
+		return helper.HandleError(c, err)
+	}
+
+	sessionID, _ := c.Locals("sessionID").(string)
+	ticket, expiresAt, err := websockets.Tickets.Issue(user.ID, user.Username, sessionID, 0)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Error issuing the ticket",
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"message":   "Ticket issued successfully",
+		"ticket":    ticket,
+		"expiresAt": expiresAt,
+	})
+}
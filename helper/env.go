@@ -0,0 +1,24 @@
+package helper
+
+import (
+	"log"
+	"os"
+	"time"
+)
+
+// DurationFromEnv reads a Go duration (e.g. "30s") from the environment,
+// keeping the fallback when it is unset or invalid
+func DurationFromEnv(key string, fallback time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		log.Printf("Invalid %s %q, using %s", key, value, fallback)
+		return fallback
+	}
+
+	return duration
+}
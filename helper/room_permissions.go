@@ -0,0 +1,64 @@
+package helper
+
+import (
+	"realtime-todos/models"
+)
+
+// Permission is something a member may be allowed to do in a room
+type Permission int
+
+const (
+	// PermissionView covers reading the room, its todos and presence
+	PermissionView Permission = iota
+	// PermissionComment is reserved for commenting on todos
+	PermissionComment
+	// PermissionEditTodos covers creating, updating and reordering todos and
+	// deleting one's own
+	PermissionEditTodos
+	// PermissionManageTodos covers deleting other members' todos
+	PermissionManageTodos
+	// PermissionManageRoom covers renaming and deleting the room
+	PermissionManageRoom
+	// PermissionManageMembers covers adding and removing members and changing their roles
+	PermissionManageMembers
+)
+
+var rolePermissions = map[string][]Permission{
+	models.RoleOwner:     {PermissionView, PermissionComment, PermissionEditTodos, PermissionManageTodos, PermissionManageRoom, PermissionManageMembers},
+	models.RoleEditor:    {PermissionView, PermissionComment, PermissionEditTodos},
+	models.RoleCommenter: {PermissionView, PermissionComment},
+	models.RoleViewer:    {PermissionView},
+}
+
+// IsValidRole reports whether role is one of the known room roles
+func IsValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// HasPermission reports whether the role grants the permission
+func HasPermission(role string, permission Permission) bool {
+	for _, p := range rolePermissions[role] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// GetRoomRole returns the user's role in the room, which needs Members preloaded
+func GetRoomRole(user models.User, room models.Room) (string, bool) {
+	for _, member := range room.Members {
+		if member.UserID == user.ID {
+			return member.Role, true
+		}
+	}
+	return "", false
+}
+
+// CanInRoom reports whether the user is a member of the room whose role grants
+// the permission. The room needs Members preloaded.
+func CanInRoom(user models.User, room models.Room, permission Permission) bool {
+	role, ok := GetRoomRole(user, room)
+	return ok && HasPermission(role, permission)
+}
@@ -3,6 +3,7 @@ package initialisers
 import (
 	"log"
 	"os"
+	"realtime-todos/models"
 
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
@@ -19,4 +20,22 @@ func ConnectDB() {
 	} else {
 		log.Println("DB Connection Established")
 	}
+
+	if err := models.SetupJoinTables(DB); err != nil {
+		log.Fatalln("Error setting up join tables:", err)
+	}
+}
+
+func CloseDB() {
+	sqlDB, err := DB.DB()
+	if err != nil {
+		log.Println("Error getting the database pool:", err)
+		return
+	}
+
+	if err := sqlDB.Close(); err != nil {
+		log.Println("Error closing the database pool:", err)
+	} else {
+		log.Println("DB Connection Closed")
+	}
 }
@@ -0,0 +1,62 @@
+package mailer
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// Message is a plain text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends emails
+type Mailer interface {
+	Send(message Message) error
+}
+
+// Default is the mailer the application sends through. It is nil until
+// FromEnv configures one, and features that send email are off meanwhile.
+var Default Mailer
+
+// FromEnv builds the mailer selected by MAILER: "smtp" (SMTP_HOST, SMTP_PORT,
+// SMTP_USERNAME, SMTP_PASSWORD, MAIL_FROM), "file" (MAIL_FILE) or "log". The
+// last two write messages, reset tokens included, where anyone reading the
+// logs or the file sees them, so they have to be asked for explicitly. Without
+// MAILER it returns nil.
+func FromEnv() (Mailer, error) {
+	switch backend := os.Getenv("MAILER"); backend {
+	case "":
+		return nil, nil
+	case "log":
+		return &FileMailer{}, nil
+	case "file":
+		path := os.Getenv("MAIL_FILE")
+		if path == "" {
+			return nil, fmt.Errorf("MAIL_FILE is not set")
+		}
+		return &FileMailer{Path: path}, nil
+	case "smtp":
+		port, err := strconv.Atoi(os.Getenv("SMTP_PORT"))
+		if err != nil {
+			port = 587
+		}
+
+		mailer := &SMTPMailer{
+			Host:     os.Getenv("SMTP_HOST"),
+			Port:     port,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     os.Getenv("MAIL_FROM"),
+		}
+		if mailer.Host == "" || mailer.From == "" {
+			return nil, fmt.Errorf("SMTP_HOST and MAIL_FROM are required")
+		}
+		return mailer, nil
+	default:
+		return nil, fmt.Errorf("unknown MAILER %q", backend)
+	}
+}
@@ -1,16 +1,26 @@
 package middlewares
 
 import (
+	"errors"
 	"fmt"
 	"log"
-	"os"
+	"realtime-todos/helper"
 	"strings"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/golang-jwt/jwt/v5"
 )
 
-var ignoredRoutes = []string{"/api/register", "/api/login", "/api"}
+var ignoredRoutes = []string{
+	"/api/register",
+	"/api/login",
+	"/api/login/2fa",
+	"/api/token/refresh",
+	"/api/password/reset-request",
+	"/api/password/reset-confirm",
+	"/api",
+}
 
 func isIgnoredRoute(c *fiber.Ctx) bool {
 	for _, route := range ignoredRoutes {
@@ -42,34 +52,87 @@ func CheckAuth() fiber.Handler {
 			})
 		}
 
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-			}
-			return []byte(os.Getenv("JWT_SECRET")), nil
-		})
-
+		claims, err := ParseAccessToken(tokenString)
+		if errors.Is(err, jwt.ErrTokenExpired) {
+			// Clients refresh on this code instead of logging the user out
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Access token expired",
+				"code":  "token_expired",
+			})
+		}
+		if errors.Is(err, ErrTokenRevoked) {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Session has been logged out",
+				"code":  "token_revoked",
+			})
+		}
 		if err != nil {
 			log.Println("Token Parsing Error:", err)
 			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-				"error": "Invalid or expired token",
+				"error": "Invalid token",
+				"code":  "invalid_token",
 			})
 		}
 
-		if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-			username, ok := claims["username"].(string)
-			if !ok {
-				return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-					"error": "Invalid token claims",
-				})
-			}
+		c.Locals("username", claims.Username)
+		c.Locals("sessionID", claims.SessionID)
+		return c.Next()
+	}
+}
 
-			c.Locals("username", username)
-			return c.Next()
-		}
+// ErrTokenRevoked is returned for access tokens of a session that was logged out
+var ErrTokenRevoked = errors.New("token has been revoked")
+
+// AccessTokenTTL is how long an access token is valid, ACCESS_TOKEN_TTL
+func AccessTokenTTL() time.Duration {
+	return helper.DurationFromEnv("ACCESS_TOKEN_TTL", 15*time.Minute)
+}
 
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"error": "Invalid token",
-		})
+// AccessClaims are the claims of a valid access token. SessionID is its jti,
+// shared by every access token of the session.
+type AccessClaims struct {
+	Username  string
+	SessionID string
+}
+
+// ParseToken validates a signed access token and returns the username it was
+// issued for
+func ParseToken(tokenString string) (string, error) {
+	claims, err := ParseAccessToken(tokenString)
+	return claims.Username, err
+}
+
+// ParseAccessToken validates a signed access token and returns its claims.
+// Expired tokens fail with jwt.ErrTokenExpired, tokens of revoked sessions
+// with ErrTokenRevoked.
+func ParseAccessToken(tokenString string) (AccessClaims, error) {
+	token, err := jwt.Parse(tokenString, Keys.Keyfunc, jwt.WithExpirationRequired())
+	if err != nil {
+		return AccessClaims{}, err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return AccessClaims{}, fmt.Errorf("invalid token")
+	}
+
+	if typ, _ := claims["typ"].(string); typ != "access" {
+		return AccessClaims{}, fmt.Errorf("not an access token")
 	}
+
+	username, ok := claims["username"].(string)
+	if !ok {
+		return AccessClaims{}, fmt.Errorf("invalid token claims")
+	}
+
+	jti, ok := claims["jti"].(string)
+	if !ok || jti == "" {
+		return AccessClaims{}, fmt.Errorf("invalid token claims")
+	}
+
+	if Revocations.IsRevoked(jti) {
+		return AccessClaims{}, ErrTokenRevoked
+	}
+
+	return AccessClaims{Username: username, SessionID: jti}, nil
 }
@@ -0,0 +1,236 @@
+package middlewares
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Tokens are signed with one key of the keyring and verified with whichever
+// key their kid header names, so keys can be rotated without logging anyone
+// out: add the new key, make it the signing key, and remove the old one once
+// the tokens it signed have expired.
+//
+// JWT_KEYS_DIR holds one PEM file per key, named <kid>.pem. Private keys
+// (Ed25519 or RSA) can sign and verify, public keys only verify.
+// JWT_SIGNING_KID picks the signing key. JWT_SECRET, if set, is kept as an
+// HS256 key with kid "secret" which also verifies tokens without a kid, and
+// signs when JWT_SIGNING_KID is unset.
+
+// secretKID is the kid of the HS256 key read from JWT_SECRET
+const secretKID = "secret"
+
+// minRSABits is the smallest RSA key accepted for RS256
+const minRSABits = 2048
+
+type keyringKey struct {
+	kid    string
+	method jwt.SigningMethod
+	// signKey is nil for verify-only keys
+	signKey   interface{}
+	verifyKey interface{}
+}
+
+// Keyring holds the keys access tokens are signed and verified with
+type Keyring struct {
+	keys    map[string]*keyringKey
+	signing *keyringKey
+}
+
+// Keys is the global keyring, see LoadKeyring
+var Keys *Keyring
+
+// LoadKeyring reads the keys from the environment into Keys
+func LoadKeyring() error {
+	keyring, err := NewKeyringFromEnv()
+	if err != nil {
+		return err
+	}
+
+	Keys = keyring
+	return nil
+}
+
+// NewKeyringFromEnv builds a keyring from JWT_KEYS_DIR, JWT_SIGNING_KID and JWT_SECRET
+func NewKeyringFromEnv() (*Keyring, error) {
+	keyring := &Keyring{keys: make(map[string]*keyringKey)}
+
+	if secret := os.Getenv("JWT_SECRET"); secret != "" {
+		keyring.keys[secretKID] = &keyringKey{
+			kid:       secretKID,
+			method:    jwt.SigningMethodHS256,
+			signKey:   []byte(secret),
+			verifyKey: []byte(secret),
+		}
+	}
+
+	if dir := os.Getenv("JWT_KEYS_DIR"); dir != "" {
+		paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, path := range paths {
+			key, err := loadPEMKey(path)
+			if err != nil {
+				return nil, fmt.Errorf("loading %s: %w", path, err)
+			}
+			if key.kid == secretKID {
+				return nil, fmt.Errorf("loading %s: kid %q is reserved for JWT_SECRET", path, secretKID)
+			}
+			keyring.keys[key.kid] = key
+		}
+	}
+
+	signingKID := os.Getenv("JWT_SIGNING_KID")
+	if signingKID == "" {
+		signingKID = secretKID
+	}
+
+	signing, ok := keyring.keys[signingKID]
+	if !ok {
+		return nil, fmt.Errorf("signing key %q not found, set JWT_SIGNING_KID or JWT_SECRET", signingKID)
+	}
+	if signing.signKey == nil {
+		return nil, fmt.Errorf("signing key %q is a public key", signingKID)
+	}
+	keyring.signing = signing
+
+	return keyring, nil
+}
+
+// loadPEMKey reads a key file, taking the kid from the file name
+func loadPEMKey(path string) (*keyringKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block found")
+	}
+
+	key := &keyringKey{kid: strings.TrimSuffix(filepath.Base(path), ".pem")}
+
+	var parsed interface{}
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch k := parsed.(type) {
+	case ed25519.PrivateKey:
+		key.method = jwt.SigningMethodEdDSA
+		key.signKey = k
+		key.verifyKey = k.Public()
+	case ed25519.PublicKey:
+		key.method = jwt.SigningMethodEdDSA
+		key.verifyKey = k
+	case *rsa.PrivateKey:
+		key.method = jwt.SigningMethodRS256
+		key.signKey = k
+		key.verifyKey = &k.PublicKey
+	case *rsa.PublicKey:
+		key.method = jwt.SigningMethodRS256
+		key.verifyKey = k
+	default:
+		return nil, fmt.Errorf("unsupported key type %T, use Ed25519 or RSA", parsed)
+	}
+
+	if rsaKey, ok := key.verifyKey.(*rsa.PublicKey); ok && rsaKey.N.BitLen() < minRSABits {
+		return nil, fmt.Errorf("RSA keys must be at least %d bits", minRSABits)
+	}
+
+	return key, nil
+}
+
+// Sign signs the claims with the signing key, naming it in the kid header
+func (k *Keyring) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(k.signing.method, claims)
+	token.Header["kid"] = k.signing.kid
+	return token.SignedString(k.signing.signKey)
+}
+
+// Keyfunc finds the key a token was signed with. Tokens without a kid
+// predate the keyring and can only be checked against JWT_SECRET.
+func (k *Keyring) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		kid = secretKID
+	}
+
+	key, ok := k.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	// The algorithm is fixed by the key, never by the token
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+
+	return key.verifyKey, nil
+}
+
+// JWK is a public key in JSON Web Key format
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	// Crv and X describe Ed25519 keys
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	// N and E describe RSA keys
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+}
+
+// JWKS returns the public keys tokens can be verified with, sorted by kid.
+// The JWT_SECRET key is left out, it cannot be published.
+func (k *Keyring) JWKS() []JWK {
+	jwks := make([]JWK, 0, len(k.keys))
+	for _, key := range k.keys {
+		jwk := JWK{Kid: key.kid, Use: "sig", Alg: key.method.Alg()}
+
+		switch pub := key.verifyKey.(type) {
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		default:
+			continue
+		}
+
+		jwks = append(jwks, jwk)
+	}
+
+	sort.Slice(jwks, func(i, j int) bool {
+		return jwks[i].Kid < jwks[j].Kid
+	})
+	return jwks
+}
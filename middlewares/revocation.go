@@ -0,0 +1,82 @@
+package middlewares
+
+import (
+	"log"
+	"realtime-todos/initialisers"
+	"realtime-todos/models"
+	"sync"
+	"time"
+)
+
+// revocationReloadInterval is how often the revocation list is reloaded from
+// the database to pick up sessions revoked on other instances
+const revocationReloadInterval = 30 * time.Second
+
+// RevocationList caches the jti of recently revoked sessions so that checking
+// an access token does not hit the database. A revoked jti is kept for
+// AccessTokenTTL, after which every token carrying it has expired anyway.
+type RevocationList struct {
+	revoked  map[string]time.Time
+	loadedAt time.Time
+	mu       sync.RWMutex
+}
+
+var (
+	// Revocations is the global instance of RevocationList
+	Revocations = &RevocationList{
+		revoked: make(map[string]time.Time),
+	}
+)
+
+// Revoke rejects the session's access tokens on this instance right away,
+// other instances notice on their next reload
+func (l *RevocationList) Revoke(jti string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.revoked[jti] = time.Now().Add(AccessTokenTTL())
+}
+
+// IsRevoked reports whether the session of an access token was revoked
+func (l *RevocationList) IsRevoked(jti string) bool {
+	l.reloadIfStale()
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	_, ok := l.revoked[jti]
+	return ok
+}
+
+func (l *RevocationList) reloadIfStale() {
+	// Whoever finds the list stale first reloads it, everyone else keeps
+	// using the current one meanwhile
+	l.mu.Lock()
+	if time.Since(l.loadedAt) < revocationReloadInterval {
+		l.mu.Unlock()
+		return
+	}
+	l.loadedAt = time.Now()
+	l.mu.Unlock()
+
+	var sessions []models.Session
+	if err := initialisers.DB.Select("token_id", "revoked_at").
+		Where("revoked_at > ?", time.Now().Add(-AccessTokenTTL())).
+		Find(&sessions).Error; err != nil {
+		log.Printf("Error loading revoked sessions: %v", err)
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	for jti, until := range l.revoked {
+		if now.After(until) {
+			delete(l.revoked, jti)
+		}
+	}
+	for _, session := range sessions {
+		l.revoked[session.TokenID] = session.RevokedAt.Add(AccessTokenTTL())
+	}
+}
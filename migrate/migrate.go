@@ -11,5 +11,8 @@ func init() {
 }
 
 func main() {
-	initialisers.DB.AutoMigrate(&models.Room{}, &models.User{}, &models.Todo{})
+	initialisers.DB.AutoMigrate(&models.Room{}, &models.User{}, &models.Todo{}, &models.RoomUser{}, &models.Invitation{}, &models.InviteLink{}, &models.RefreshToken{}, &models.Session{}, &models.PasswordResetToken{}, &models.RecoveryCode{}, &models.WebSocketTicket{}, &models.MFAChallenge{}, &models.EditLock{})
+
+	// Members that joined before roles existed default to editor, the admin owns the room
+	initialisers.DB.Exec("UPDATE room_users SET role = ? FROM rooms WHERE rooms.id = room_users.room_id AND rooms.admin_id = room_users.user_id", models.RoleOwner)
 }
@@ -1,22 +1,73 @@
 package models
 
-import "gorm.io/gorm"
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
 
 type User struct {
 	gorm.Model
 	Username string `gorm:"uniqueIndex;not null;size:255" json:"username"`
 	Password string `gorm:"not null;size:255" json:"-"`
-	Rooms    []Room `gorm:"many2many:room_users;constraint:OnDelete:CASCADE;" json:"rooms"`
-	Todos    []Todo `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE;" json:"todos"`
+	// Email is optional and only used for password resets. It is left out of
+	// the JSON since users are sent to other room members.
+	Email *string `gorm:"uniqueIndex;size:255" json:"-"`
+	// TOTPSecret is set once two-factor setup starts, TOTPEnabled once the
+	// first code was verified. TOTPLastStep is the time step of the last code
+	// accepted, which cannot be used again.
+	TOTPSecret   *string `gorm:"size:64" json:"-"`
+	TOTPEnabled  bool    `gorm:"not null;default:false" json:"-"`
+	TOTPLastStep int64   `gorm:"not null;default:0" json:"-"`
+	Rooms        []Room  `gorm:"many2many:room_users;constraint:OnDelete:CASCADE;" json:"rooms"`
+	Todos        []Todo  `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE;" json:"todos"`
+
+	// MFAFailures counts second factor attempts since the last successful
+	// one across all login challenges, MFAFailedAt is when the last was made
+	MFAFailures int        `gorm:"not null;default:0" json:"-"`
+	MFAFailedAt *time.Time `json:"-"`
+	// PasswordResetSentAt is when the last reset email went out, later
+	// requests within the cooldown are dropped
+	PasswordResetSentAt *time.Time `json:"-"`
 }
 
 type Room struct {
 	gorm.Model
-	Name    string `gorm:"not null;size:255" json:"name"`
-	AdminID uint   `gorm:"not null" json:"adminId"`
-	Admin   User   `gorm:"foreignKey:AdminID" json:"admin"`
-	Users   []User `gorm:"many2many:room_users;constraint:OnDelete:CASCADE;" json:"users"`
-	Todos   []Todo `gorm:"foreignKey:RoomID;constraint:OnDelete:CASCADE;" json:"todos"`
+	Name    string     `gorm:"not null;size:255" json:"name"`
+	AdminID uint       `gorm:"not null" json:"adminId"`
+	Admin   User       `gorm:"foreignKey:AdminID" json:"admin"`
+	Users   []User     `gorm:"many2many:room_users;constraint:OnDelete:CASCADE;" json:"users"`
+	Members []RoomUser `gorm:"foreignKey:RoomID" json:"members"`
+	Todos   []Todo     `gorm:"foreignKey:RoomID;constraint:OnDelete:CASCADE;" json:"todos"`
+	// PublicTokenHash is set while the room is shared read-only through a
+	// public link, PublicUsernames lets that link show who created each todo
+	PublicTokenHash *string `gorm:"uniqueIndex;size:64" json:"-"`
+	PublicUsernames bool    `gorm:"not null;default:false" json:"publicUsernames"`
+}
+
+// Roles a member can have in a room, see helper.HasPermission for what each may do
+const (
+	RoleOwner     = "owner"
+	RoleEditor    = "editor"
+	RoleCommenter = "commenter"
+	RoleViewer    = "viewer"
+)
+
+// RoomUser is the room_users join table, carrying the member's role
+type RoomUser struct {
+	RoomID    uint      `gorm:"primaryKey" json:"roomId"`
+	UserID    uint      `gorm:"primaryKey" json:"userId"`
+	Role      string    `gorm:"not null;size:32;default:editor" json:"role"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// SetupJoinTables tells gorm to use RoomUser for the room_users many2many
+// relation. It has to run before the relation is used or migrated.
+func SetupJoinTables(db *gorm.DB) error {
+	if err := db.SetupJoinTable(&Room{}, "Users", &RoomUser{}); err != nil {
+		return err
+	}
+	return db.SetupJoinTable(&User{}, "Rooms", &RoomUser{})
 }
 
 type Todo struct {
@@ -29,3 +80,126 @@ type Todo struct {
 	IsCompleted bool   `gorm:"default:false" json:"isCompleted"`
 	Order       uint   `gorm:"default:0" json:"order"`
 }
+
+// Invitation states, a pending invitation past ExpiresAt counts as expired
+const (
+	InvitationPending  = "pending"
+	InvitationAccepted = "accepted"
+	InvitationDeclined = "declined"
+	InvitationExpired  = "expired"
+)
+
+// Invitation asks a user to join a room with the given role
+type Invitation struct {
+	gorm.Model
+	RoomID      uint       `gorm:"not null;index" json:"roomId"`
+	Room        Room       `gorm:"foreignKey:RoomID;constraint:OnDelete:CASCADE;" json:"room"`
+	InviterID   uint       `gorm:"not null" json:"inviterId"`
+	Inviter     User       `gorm:"foreignKey:InviterID" json:"inviter"`
+	InviteeID   uint       `gorm:"not null;index" json:"inviteeId"`
+	Invitee     User       `gorm:"foreignKey:InviteeID;constraint:OnDelete:CASCADE;" json:"invitee"`
+	Role        string     `gorm:"not null;size:32" json:"role"`
+	Status      string     `gorm:"not null;size:16;default:pending;index" json:"status"`
+	ExpiresAt   time.Time  `gorm:"not null" json:"expiresAt"`
+	RespondedAt *time.Time `json:"respondedAt"`
+}
+
+// InviteLink lets anyone holding its signed token join the room with Role,
+// until it expires or has been used MaxUses times. Nil ExpiresAt and zero
+// MaxUses mean no limit.
+type InviteLink struct {
+	gorm.Model
+	RoomID    uint       `gorm:"not null;index" json:"roomId"`
+	Room      Room       `gorm:"foreignKey:RoomID;constraint:OnDelete:CASCADE;" json:"-"`
+	CreatorID uint       `gorm:"not null" json:"creatorId"`
+	Creator   User       `gorm:"foreignKey:CreatorID" json:"creator"`
+	Role      string     `gorm:"not null;size:32" json:"role"`
+	Nonce     string     `gorm:"not null;size:64" json:"-"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+	MaxUses   uint       `gorm:"not null;default:0" json:"maxUses"`
+	Uses      uint       `gorm:"not null;default:0" json:"uses"`
+}
+
+// RefreshToken is a single-use token exchanged for a new access token and a
+// new refresh token of the same family. Only its hash is stored.
+type RefreshToken struct {
+	gorm.Model
+	UserID    uint      `gorm:"not null;index"`
+	User      User      `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE;"`
+	FamilyID  string    `gorm:"not null;size:64;index"`
+	TokenHash string    `gorm:"not null;size:64;uniqueIndex"`
+	ExpiresAt time.Time `gorm:"not null"`
+	UsedAt    *time.Time
+	RevokedAt *time.Time
+}
+
+// Session is one login of a user on a device. TokenID is the jti of the
+// session's access tokens and the family of its refresh tokens.
+type Session struct {
+	gorm.Model
+	UserID     uint       `gorm:"not null;index" json:"-"`
+	User       User       `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE;" json:"-"`
+	TokenID    string     `gorm:"not null;size:64;uniqueIndex" json:"-"`
+	UserAgent  string     `gorm:"size:255" json:"userAgent"`
+	IP         string     `gorm:"size:64" json:"ip"`
+	LastUsedAt time.Time  `gorm:"not null" json:"lastUsedAt"`
+	RevokedAt  *time.Time `gorm:"index" json:"-"`
+}
+
+// PasswordResetToken lets the user set a new password once, before ExpiresAt.
+// Only its hash is stored.
+type PasswordResetToken struct {
+	gorm.Model
+	UserID    uint      `gorm:"not null;index"`
+	User      User      `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE;"`
+	TokenHash string    `gorm:"not null;size:64;uniqueIndex"`
+	ExpiresAt time.Time `gorm:"not null"`
+	UsedAt    *time.Time
+}
+
+// RecoveryCode is a single-use code that stands in for a TOTP code when the
+// authenticator is lost. Only its hash is stored.
+type RecoveryCode struct {
+	gorm.Model
+	UserID   uint   `gorm:"not null;index"`
+	User     User   `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE;"`
+	CodeHash string `gorm:"not null;size:64;index"`
+	UsedAt   *time.Time
+}
+
+// EditLock is the advisory lock one connection holds on a todo while its user
+// edits it. The primary key on TodoID is what lets only one connection, on
+// whichever instance, take the lock.
+type EditLock struct {
+	TodoID       uint      `gorm:"primaryKey;autoIncrement:false"`
+	RoomID       uint      `gorm:"not null"`
+	UserID       uint      `gorm:"not null"`
+	Username     string    `gorm:"not null;size:255"`
+	ConnectionID string    `gorm:"not null;size:64;index"`
+	ExpiresAt    time.Time `gorm:"not null;index"`
+}
+
+// WebSocketTicket is a single-use connect ticket for a room socket or, with a
+// RoomID of 0, the user channel. Tickets live in the database so any instance
+// can redeem one issued by another. Only the hash of the ticket is stored.
+type WebSocketTicket struct {
+	ID        uint      `gorm:"primarykey"`
+	TokenHash string    `gorm:"not null;size:64;uniqueIndex"`
+	UserID    uint      `gorm:"not null"`
+	Username  string    `gorm:"not null;size:255"`
+	RoomID    uint      `gorm:"not null"`
+	ExpiresAt time.Time `gorm:"not null;index"`
+	// SessionID is the session the ticket was issued to, whose logout closes
+	// the connection opened with it
+	SessionID string `gorm:"size:64"`
+}
+
+// MFAChallenge counts the codes tried for one login challenge, keyed by the
+// challenge token's jti, so the limit holds across instances. The row is
+// created with the challenge and deleted once it was used to log in.
+type MFAChallenge struct {
+	ID        uint      `gorm:"primarykey"`
+	JTI       string    `gorm:"column:jti;not null;size:64;uniqueIndex"`
+	Attempts  int       `gorm:"not null;default:0"`
+	ExpiresAt time.Time `gorm:"not null;index"`
+}
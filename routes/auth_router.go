@@ -2,12 +2,47 @@ package routes
 
 import (
 	"realtime-todos/controllers"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/limiter"
 )
 
+// passwordResetLimiter keeps a single client from flooding inboxes and the
+// reset token table, on top of the per-account cooldown
+func passwordResetLimiter() fiber.Handler {
+	return limiter.New(limiter.Config{
+		Max:        5,
+		Expiration: 15 * time.Minute,
+		KeyGenerator: func(c *fiber.Ctx) string {
+			return c.IP()
+		},
+		LimitReached: func(c *fiber.Ctx) error {
+			return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+				"error": "Too many password reset requests, please try again later",
+			})
+		},
+	})
+}
+
 func AuthRouter(api fiber.Router) {
 	api.Post("/register", controllers.Register)
 	api.Post("/login", controllers.Login)
+	api.Post("/login/2fa", controllers.LoginTwoFactor)
+	api.Post("/token/refresh", controllers.RefreshToken)
+	api.Post("/password/reset-request", passwordResetLimiter(), controllers.RequestPasswordReset)
+	api.Post("/password/reset-confirm", controllers.ConfirmPasswordReset)
+	api.Post("/logout", controllers.Logout)
+	api.Get("/sessions", controllers.GetSessions)
+	api.Delete("/sessions", controllers.DeleteSessions)
+	api.Delete("/sessions/:sessionID", controllers.DeleteSession)
 	api.Get("/me", controllers.Me)
+	api.Delete("/me", controllers.DeleteAccount)
+	api.Post("/me/password", controllers.ChangePassword)
+	api.Put("/me/email", controllers.UpdateEmail)
+	api.Post("/me/2fa/setup", controllers.SetupTwoFactor)
+	api.Post("/me/2fa/enable", controllers.EnableTwoFactor)
+	api.Post("/me/2fa/disable", controllers.DisableTwoFactor)
+	api.Post("/me/2fa/recovery-codes", controllers.RegenerateRecoveryCodes)
+	api.Post("/ws-ticket", controllers.IssueUserWebSocketTicket)
 }
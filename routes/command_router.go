@@ -0,0 +1,13 @@
+package routes
+
+import (
+	"realtime-todos/controllers"
+	"realtime-todos/websockets"
+)
+
+func CommandRouter(hub *websockets.RoomHub) {
+	hub.RegisterCommand("create_todo", controllers.CreateTodoCommand)
+	hub.RegisterCommand("update_todo", controllers.UpdateTodoCommand)
+	hub.RegisterCommand("delete_todo", controllers.DeleteTodoCommand)
+	hub.RegisterCommand("reorder", controllers.ReorderTodosCommand)
+}
@@ -10,4 +10,5 @@ func IndexRouter(api fiber.Router) {
 	api.Get("/", controllers.HealthCheck)
 	AuthRouter(api)
 	RoomRouter(api)
+	InvitationRouter(api)
 }
@@ -0,0 +1,19 @@
+package routes
+
+import (
+	"realtime-todos/controllers"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func InvitationRouter(api fiber.Router) {
+	api.Get("/invitations", controllers.GetMyInvitations)
+	api.Post("/invitations/:invitationID/accept", controllers.AcceptInvitation)
+	api.Post("/invitations/:invitationID/decline", controllers.DeclineInvitation)
+	api.Get("/room/:roomID/invitations", controllers.GetRoomInvitations)
+	api.Delete("/room/:roomID/invitations/:invitationID", controllers.RevokeInvitation)
+	api.Post("/room/:roomID/invite-links", controllers.CreateInviteLink)
+	api.Get("/room/:roomID/invite-links", controllers.GetInviteLinks)
+	api.Delete("/room/:roomID/invite-links/:linkID", controllers.RevokeInviteLink)
+	api.Post("/invite/:token/join", controllers.JoinWithInviteLink)
+}
@@ -0,0 +1,17 @@
+package routes
+
+import (
+	"realtime-todos/controllers"
+	"realtime-todos/websockets"
+
+	"github.com/gofiber/contrib/websocket"
+	"github.com/gofiber/fiber/v2"
+)
+
+// PublicRouter registers the read-only share link endpoints, which have to
+// come before CheckAuth
+func PublicRouter(api fiber.Router, config websocket.Config) {
+	api.Get("/public/rooms/:token", controllers.GetPublicRoom)
+	api.Get("/public/rooms/:token/events", websockets.Hub.AuthorizePublicRoom, websockets.Hub.HandleEventStream)
+	api.Get("/public/rooms/:token/ws", websockets.Hub.AuthorizePublicRoom, websocket.New(websockets.Hub.HandleConnection, config))
+}
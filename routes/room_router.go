@@ -13,11 +13,17 @@ func RoomRouter(api fiber.Router) {
 	api.Get("/room/:roomID", controllers.GetRoom)
 	api.Patch("/room/:roomID", controllers.UpdateRoom)
 	api.Get("/room/:roomID/todos", controllers.GetRoomTodos)
+	api.Get("/room/:roomID/presence", controllers.GetRoomPresence)
 	api.Post("/room/:roomID/todo", controllers.AddTodo)
 	api.Delete("/room/:roomID/todo/:todoID", controllers.RemoveTodo)
 	api.Patch("/room/:roomID/todo/:todoID", controllers.UpdateTodo)
 	api.Post("/room/:roomID/user", controllers.AddUserToRoom)
 	api.Delete("/room/:roomID/user/remove", controllers.RemoveUserFromRoom)
 	api.Delete("/room/:roomID/user/leave", controllers.LeaveRoom)
+	api.Patch("/room/:roomID/user/role", controllers.UpdateMemberRole)
+	api.Post("/room/:roomID/transfer", controllers.TransferRoom)
+	api.Post("/room/:roomID/public", controllers.ShareRoom)
+	api.Delete("/room/:roomID/public", controllers.UnshareRoom)
 	api.Patch("/room/:roomID/todos", controllers.ReorderTodos)
+	api.Post("/room/:roomID/ws-ticket", controllers.IssueWebSocketTicket)
 }
@@ -1,11 +1,17 @@
 package main
 
 import (
+	"context"
+	"log"
 	"os"
+	"os/signal"
+	"realtime-todos/controllers"
 	"realtime-todos/initialisers"
+	"realtime-todos/mailer"
 	"realtime-todos/middlewares"
 	"realtime-todos/routes"
 	"realtime-todos/websockets"
+	"syscall"
 	"time"
 
 	"github.com/gofiber/contrib/websocket"
@@ -18,10 +24,18 @@ import (
 func init() {
 	initialisers.LoadEnv()
 	initialisers.ConnectDB()
+	if err := middlewares.LoadKeyring(); err != nil {
+		log.Fatalln("Error loading signing keys:", err)
+	}
+	if err := controllers.LoadInviteLinkSecret(); err != nil {
+		log.Fatalln("Error loading the invite link secret:", err)
+	}
 }
 
 func main() {
 	app := fiber.New()
+	setupPubSub()
+	setupMailer()
 	setupMiddlewares(app)
 	setupRoutes(app)
 	setupWebSocketRoutes(app)
@@ -32,6 +46,17 @@ func main() {
 func setupRoutes(app *fiber.App) {
 	api := app.Group("/api")
 	app.Use(logger.New())
+
+	// Lets other services verify our access tokens
+	app.Get("/.well-known/jwks.json", controllers.GetJWKS)
+
+	// Registered ahead of CheckAuth since EventSource cannot send an
+	// Authorization header, the stream authenticates on its own
+	api.Get("/room/:roomID/events", middlewares.CheckWebSocketAuth(), websockets.Hub.AuthorizeRoom, websockets.Hub.HandleEventStream)
+
+	// Public share links are read by people without an account
+	routes.PublicRouter(api, websocketConfig())
+
 	api.Use(middlewares.CheckAuth())
 
 	api.Use(limiter.New(limiter.Config{
@@ -75,7 +100,55 @@ func setupMiddlewares(app *fiber.App) {
 	}))
 }
 
+// setupMailer picks how emails are sent, see mailer.FromEnv
+func setupMailer() {
+	m, err := mailer.FromEnv()
+	if err != nil {
+		log.Fatalln("Error configuring the mailer:", err)
+	}
+	if m == nil {
+		log.Println("MAILER is not set, password reset is disabled")
+	}
+	mailer.Default = m
+}
+
+// setupPubSub picks the backend that fans room broadcasts out. Set
+// PUBSUB_BACKEND=postgres when running more than one instance.
+func setupPubSub() {
+	switch os.Getenv("PUBSUB_BACKEND") {
+	case "", "memory":
+	case "postgres":
+		pubsub := websockets.NewPostgresPubSub(os.Getenv("DATABASE_URL"), initialisers.DB)
+		if err := websockets.Hub.UsePubSub(pubsub); err != nil {
+			log.Fatalln("Error starting the Postgres pub/sub listener:", err)
+		}
+		log.Println("Broadcasting room events through Postgres LISTEN/NOTIFY")
+	default:
+		log.Fatalln("Unknown PUBSUB_BACKEND:", os.Getenv("PUBSUB_BACKEND"))
+	}
+}
+
+// websocketConfig is shared by every WebSocket endpoint
+func websocketConfig() websocket.Config {
+	return websocket.Config{
+		Origins: []string{
+			"http://localhost:3000",
+			"http://localhost:5173",
+			"http://localhost:8080",
+			"https://todos.actuallyakshat.in",
+			"https://realtime-todos-production-0222.up.railway.app",
+		},
+		Subprotocols:      []string{middlewares.WebSocketTokenProtocol},
+		EnableCompression: true,
+		HandshakeTimeout:  10 * time.Second,
+	}
+}
+
 func setupWebSocketRoutes(app *fiber.App) {
+	websockets.Hub.Configure(websockets.HubConfigFromEnv())
+	websockets.Hub.StartPresence()
+	routes.CommandRouter(websockets.Hub)
+
 	app.Use("/ws", func(c *fiber.Ctx) error {
 		// Allow preflight checks
 		if c.Method() == "OPTIONS" {
@@ -87,19 +160,13 @@ func setupWebSocketRoutes(app *fiber.App) {
 			return c.Next()
 		}
 		return fiber.ErrUpgradeRequired
-	})
+	}, middlewares.CheckWebSocketAuth())
 
-	app.Get("/ws/:roomID", websocket.New(websockets.Hub.HandleConnection, websocket.Config{
-		Origins: []string{
-			"http://localhost:3000",
-			"http://localhost:5173",
-			"http://localhost:8080",
-			"https://todos.actuallyakshat.in",
-			"https://realtime-todos-production-0222.up.railway.app",
-		},
-		EnableCompression: true,
-		HandshakeTimeout:  10 * time.Second,
-	}))
+	config := websocketConfig()
+
+	// Registered before /ws/:roomID so "user" is not taken for a room ID
+	app.Get("/ws/user", websockets.Hub.AuthorizeUser, websocket.New(websockets.Hub.HandleUserConnection, config))
+	app.Get("/ws/:roomID", websockets.Hub.AuthorizeRoom, websocket.New(websockets.Hub.HandleConnection, config))
 }
 
 func setupStaticFiles(app *fiber.App) {
@@ -110,16 +177,60 @@ func setupStaticFiles(app *fiber.App) {
 	})
 }
 
+// Each shutdown phase gets its own deadline, so a slow socket drain cannot eat
+// the time in-flight HTTP requests have to finish
+const (
+	socketDrainTimeout  = 10 * time.Second
+	httpShutdownTimeout = 10 * time.Second
+)
+
 func startServer(app *fiber.App) {
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8080"
 	}
 
-	//For Railway
-	err := app.Listen("0.0.0.0:" + port)
+	listenErr := make(chan error, 1)
+	go func() {
+		//For Railway
+		listenErr <- app.Listen("0.0.0.0:" + port)
+	}()
 
-	if err != nil {
-		panic(err)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	select {
+	case err := <-listenErr:
+		if err != nil {
+			panic(err)
+		}
+		return
+	case sig := <-signals:
+		log.Printf("Received %s, shutting down", sig)
 	}
+
+	// The HTTP shutdown stops the listener right away and then waits for
+	// in-flight requests. It runs alongside the socket drain, which it depends
+	// on: event streams only end once the hub closes them.
+	httpDone := make(chan struct{})
+	go func() {
+		defer close(httpDone)
+
+		ctx, cancel := context.WithTimeout(context.Background(), httpShutdownTimeout)
+		defer cancel()
+
+		if err := app.ShutdownWithContext(ctx); err != nil {
+			log.Println("Error shutting down the server:", err)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), socketDrainTimeout)
+	defer cancel()
+
+	if err := websockets.Hub.Shutdown(ctx); err != nil {
+		log.Println("Error closing WebSocket connections:", err)
+	}
+
+	<-httpDone
+	initialisers.CloseDB()
 }
@@ -0,0 +1,121 @@
+package websockets
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Command is a request sent by a client over its room socket. ID is chosen by
+// the client and echoed in the reply so it can match acks to requests.
+type Command struct {
+	ID      string          `json:"id"`
+	Command string          `json:"command"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// CommandContext identifies who sent a command and from which room
+type CommandContext struct {
+	UserID   uint
+	Username string
+	RoomID   uint
+
+	conn *Connection
+}
+
+// CommandHandler runs a command and returns the result sent back in the ack
+type CommandHandler func(ctx CommandContext, payload json.RawMessage) (interface{}, error)
+
+// CommandError is a command failure whose message is safe to show the client
+type CommandError struct {
+	Status  int
+	Message string
+}
+
+func (e *CommandError) Error() string {
+	return e.Message
+}
+
+// CommandAckEvent is sent to the client when a command succeeded
+type CommandAckEvent struct {
+	ID     string      `json:"id"`
+	Result interface{} `json:"result,omitempty"`
+}
+
+// CommandErrorEvent is sent to the client when a command failed
+type CommandErrorEvent struct {
+	ID     string `json:"id"`
+	Status int    `json:"status"`
+	Error  string `json:"error"`
+}
+
+// commandRegistry holds the handlers registered through RegisterCommand
+type commandRegistry struct {
+	handlers map[string]CommandHandler
+	mu       sync.RWMutex
+}
+
+// RegisterCommand makes a command available to clients on every room socket
+func (h *RoomHub) RegisterCommand(name string, handler CommandHandler) {
+	h.commands.mu.Lock()
+	defer h.commands.mu.Unlock()
+
+	h.commands.handlers[name] = handler
+}
+
+// handleCommand runs a command read from the connection and replies to it.
+// Commands from one connection run one at a time, in the order they were sent.
+func (h *RoomHub) handleCommand(conn *Connection, data []byte) {
+	var command Command
+	if err := json.Unmarshal(data, &command); err != nil || command.Command == "" {
+		conn.reply("command_error", CommandErrorEvent{ID: command.ID, Status: fiber.StatusBadRequest, Error: "Invalid command"})
+		return
+	}
+
+	h.commands.mu.RLock()
+	handler, ok := h.commands.handlers[command.Command]
+	h.commands.mu.RUnlock()
+
+	if !ok {
+		conn.reply("command_error", CommandErrorEvent{ID: command.ID, Status: fiber.StatusNotFound, Error: "Unknown command"})
+		return
+	}
+
+	ctx := CommandContext{
+		UserID:   conn.UserID,
+		Username: conn.Username,
+		RoomID:   conn.RoomId,
+		conn:     conn,
+	}
+
+	result, err := handler(ctx, command.Payload)
+	if err != nil {
+		var commandErr *CommandError
+		if errors.As(err, &commandErr) {
+			conn.reply("command_error", CommandErrorEvent{ID: command.ID, Status: commandErr.Status, Error: commandErr.Message})
+			return
+		}
+
+		log.Printf("Error running %s for %s in room %d: %v", command.Command, conn.Username, conn.RoomId, err)
+		conn.reply("command_error", CommandErrorEvent{ID: command.ID, Status: fiber.StatusInternalServerError, Error: "Internal server error"})
+		return
+	}
+
+	conn.reply("command_ack", CommandAckEvent{ID: command.ID, Result: result})
+}
+
+// reply queues a message for this connection only
+func (conn *Connection) reply(messageType string, payload interface{}) {
+	data, err := json.Marshal(Message{Type: messageType, Payload: payload})
+	if err != nil {
+		log.Printf("Error marshaling %s: %v", messageType, err)
+		return
+	}
+
+	if !conn.enqueue(data) {
+		conn.close(CloseSlowConsumer, "send buffer full")
+	}
+}
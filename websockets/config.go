@@ -0,0 +1,45 @@
+package websockets
+
+import (
+	"log"
+	"realtime-todos/helper"
+	"time"
+)
+
+// HubConfig holds the heartbeat and deadline settings applied to every connection
+type HubConfig struct {
+	// PingInterval is how often the server pings each client
+	PingInterval time.Duration
+	// PongWait is how long a connection may stay silent before it is
+	// considered dead. It must be longer than PingInterval.
+	PongWait time.Duration
+	// WriteWait is the deadline for writing a single message to a client
+	WriteWait time.Duration
+}
+
+// DefaultHubConfig returns the settings used when nothing is configured
+func DefaultHubConfig() HubConfig {
+	return HubConfig{
+		PingInterval: 25 * time.Second,
+		PongWait:     60 * time.Second,
+		WriteWait:    10 * time.Second,
+	}
+}
+
+// HubConfigFromEnv reads WS_PING_INTERVAL, WS_PONG_WAIT and WS_WRITE_WAIT as
+// Go durations (e.g. "30s"), keeping the default for anything unset or invalid
+func HubConfigFromEnv() HubConfig {
+	config := DefaultHubConfig()
+	config.PingInterval = helper.DurationFromEnv("WS_PING_INTERVAL", config.PingInterval)
+	config.PongWait = helper.DurationFromEnv("WS_PONG_WAIT", config.PongWait)
+	config.WriteWait = helper.DurationFromEnv("WS_WRITE_WAIT", config.WriteWait)
+
+	if config.PingInterval >= config.PongWait {
+		log.Printf("WS_PING_INTERVAL (%s) must be shorter than WS_PONG_WAIT (%s), using defaults", config.PingInterval, config.PongWait)
+		defaults := DefaultHubConfig()
+		config.PingInterval = defaults.PingInterval
+		config.PongWait = defaults.PongWait
+	}
+
+	return config
+}
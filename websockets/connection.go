@@ -0,0 +1,183 @@
+package websockets
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gofiber/contrib/websocket"
+)
+
+// maxMessageSize is the largest message a client may send
+const maxMessageSize = 16 * 1024
+
+// sendBufferSize is how many outbound messages may queue up for a connection
+// before it is considered a slow consumer and dropped
+const sendBufferSize = 64
+
+// Connection represents a WebSocket connection for a specific player. Event
+// stream subscribers are Connections too, with a nil Conn.
+type Connection struct {
+	Conn     *websocket.Conn
+	RoomId   uint
+	UserID   uint
+	Username string
+	// Protocol is the event protocol version the client asked for
+	Protocol int
+	// Public marks an anonymous read-only viewer from a public share link,
+	// see publicEvents
+	Public bool
+	// SessionID is the login session the connection was authenticated with,
+	// empty for public viewers
+	SessionID string
+
+	config HubConfig
+	// id tells the connection's editing locks apart from those of the user's
+	// other tabs
+	id string
+	// editing holds the todos this connection has editing locks on. It is
+	// only touched from the goroutine running HandleConnection.
+	editing map[uint]bool
+	// send queues outbound messages for writePump, the only goroutine that
+	// writes to Conn
+	send chan []byte
+	// done is closed to ask writePump to send closeMessage and stop
+	done         chan struct{}
+	closeOnce    sync.Once
+	closeMessage []byte
+	closeCode    int
+	closeReason  string
+	// flushOnClose is false when the client is being dropped for falling behind
+	flushOnClose bool
+	// stopped is closed once writePump has returned
+	stopped chan struct{}
+}
+
+func newConnection(c *websocket.Conn, roomID uint, userID uint, username string, config HubConfig) *Connection {
+	return &Connection{
+		Conn:     c,
+		RoomId:   roomID,
+		UserID:   userID,
+		Username: username,
+		config:   config,
+		id:       newEpoch(),
+		editing:  make(map[uint]bool),
+		send:     make(chan []byte, sendBufferSize),
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+}
+
+// enqueue queues a message without blocking and reports whether it fit in the buffer
+func (conn *Connection) enqueue(message []byte) bool {
+	select {
+	case <-conn.done:
+		return false
+	default:
+	}
+
+	select {
+	case conn.send <- message:
+		return true
+	default:
+		return false
+	}
+}
+
+// close asks writePump to send a close frame with the given code and shut the
+// socket down. Only the first call has any effect.
+func (conn *Connection) close(code int, reason string) {
+	conn.closeOnce.Do(func() {
+		conn.closeMessage = websocket.FormatCloseMessage(code, reason)
+		conn.closeCode = code
+		conn.closeReason = reason
+		conn.flushOnClose = code != CloseSlowConsumer
+		close(conn.done)
+	})
+}
+
+// write sends a single text message, giving up after WriteWait
+func (conn *Connection) write(message []byte) error {
+	conn.Conn.SetWriteDeadline(time.Now().Add(conn.config.WriteWait))
+	return conn.Conn.WriteMessage(websocket.TextMessage, message)
+}
+
+// readPump passes text messages from the socket to handle until it fails.
+// Every pong pushes the read deadline forward, so a peer that stops answering
+// pings times out after PongWait.
+func (conn *Connection) readPump(handle func([]byte)) {
+	conn.Conn.SetReadLimit(maxMessageSize)
+	conn.Conn.SetReadDeadline(time.Now().Add(conn.config.PongWait))
+	conn.Conn.SetPongHandler(func(string) error {
+		return conn.Conn.SetReadDeadline(time.Now().Add(conn.config.PongWait))
+	})
+
+	for {
+		messageType, data, err := conn.Conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure, websocket.CloseNormalClosure, CloseRemovedFromRoom, CloseSessionRevoked, CloseRoomDeleted, CloseSlowConsumer) {
+				log.Printf("Unexpected close error from %s in room %d: %v", conn.Username, conn.RoomId, err)
+			}
+			return
+		}
+		if messageType == websocket.CloseMessage {
+			log.Printf("Received close message from %s in room %d", conn.Username, conn.RoomId)
+			return
+		}
+		if messageType == websocket.TextMessage {
+			handle(data)
+		}
+	}
+}
+
+// flush writes whatever is still queued so that messages broadcast right before
+// a close, such as room_deleted, reach the client
+func (conn *Connection) flush() {
+	for {
+		select {
+		case message := <-conn.send:
+			if err := conn.write(message); err != nil {
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+// writePump writes queued messages and periodic pings to the socket until the
+// connection is closed or a write fails. Closing the socket makes readPump
+// return as well.
+func (conn *Connection) writePump() {
+	ticker := time.NewTicker(conn.config.PingInterval)
+	defer func() {
+		ticker.Stop()
+		close(conn.stopped)
+	}()
+
+	for {
+		select {
+		case message := <-conn.send:
+			if err := conn.write(message); err != nil {
+				log.Printf("Error sending message to %s: %v", conn.Username, err)
+				conn.Conn.Close()
+				return
+			}
+		case <-ticker.C:
+			if err := conn.Conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(conn.config.WriteWait)); err != nil {
+				log.Printf("Error pinging %s: %v", conn.Username, err)
+				conn.Conn.Close()
+				return
+			}
+		case <-conn.done:
+			if conn.flushOnClose {
+				conn.flush()
+			}
+			if err := conn.Conn.WriteControl(websocket.CloseMessage, conn.closeMessage, time.Now().Add(conn.config.WriteWait)); err != nil {
+				log.Printf("Error sending close to %s: %v", conn.Username, err)
+			}
+			conn.Conn.Close()
+			return
+		}
+	}
+}
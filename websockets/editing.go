@@ -0,0 +1,189 @@
+package websockets
+
+import (
+	"encoding/json"
+	"log"
+	"realtime-todos/helper"
+	"realtime-todos/initialisers"
+	"realtime-todos/models"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm/clause"
+)
+
+// editLockTTL is how long an editing lock lasts unless the client sends
+// start_editing again to keep it
+const editLockTTL = time.Minute
+
+// EditingEvent says who started or stopped editing a todo
+type EditingEvent struct {
+	TodoID   uint   `json:"todoId"`
+	UserID   uint   `json:"userId"`
+	Username string `json:"username"`
+}
+
+func init() {
+	Hub.RegisterCommand("start_editing", Hub.startEditing)
+	Hub.RegisterCommand("stop_editing", Hub.stopEditing)
+}
+
+// EditingLock returns who is editing a todo, if anyone
+func (h *RoomHub) EditingLock(roomId uint, todoID uint) (EditingEvent, bool) {
+	var lock models.EditLock
+	err := initialisers.DB.Where("todo_id = ? AND room_id = ? AND expires_at > ?", todoID, roomId, time.Now()).
+		Limit(1).Find(&lock).Error
+	if err != nil {
+		log.Printf("Error loading editing lock of todo %d: %v", todoID, err)
+		return EditingEvent{}, false
+	}
+	if lock.TodoID == 0 {
+		return EditingEvent{}, false
+	}
+
+	return EditingEvent{TodoID: todoID, UserID: lock.UserID, Username: lock.Username}, true
+}
+
+// acquireEditLock gives the connection the lock on the todo, or refreshes it,
+// unless another user holds it. The insert into edit_locks decides between
+// concurrent attempts on any instance. The user's lock moves over from their
+// other tabs.
+func acquireEditLock(conn *Connection, todoID uint) (bool, error) {
+	db := initialisers.DB
+	now := time.Now()
+
+	if err := db.Where("expires_at < ?", now).Delete(&models.EditLock{}).Error; err != nil {
+		return false, err
+	}
+
+	result := db.Model(&models.EditLock{}).
+		Where("todo_id = ? AND user_id = ?", todoID, conn.UserID).
+		Updates(map[string]interface{}{"connection_id": conn.id, "expires_at": now.Add(editLockTTL)})
+	if result.Error != nil || result.RowsAffected > 0 {
+		return result.Error == nil, result.Error
+	}
+
+	result = db.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.EditLock{
+		TodoID:       todoID,
+		RoomID:       conn.RoomId,
+		UserID:       conn.UserID,
+		Username:     conn.Username,
+		ConnectionID: conn.id,
+		ExpiresAt:    now.Add(editLockTTL),
+	})
+	return result.RowsAffected > 0, result.Error
+}
+
+// releaseEditLock drops the connection's lock on the todo and reports whether
+// it still held it
+func releaseEditLock(conn *Connection, todoID uint) (bool, error) {
+	result := initialisers.DB.Where("todo_id = ? AND connection_id = ?", todoID, conn.id).Delete(&models.EditLock{})
+	return result.RowsAffected > 0, result.Error
+}
+
+func (h *RoomHub) startEditing(ctx CommandContext, payload json.RawMessage) (interface{}, error) {
+	var input struct {
+		ID uint `json:"id"`
+	}
+	if err := json.Unmarshal(payload, &input); err != nil {
+		return nil, &CommandError{Status: fiber.StatusBadRequest, Message: "Failed to parse command payload"}
+	}
+
+	// Only members who may edit todos can take an editing lock
+	var member models.RoomUser
+	if err := initialisers.DB.Where("room_id = ? AND user_id = ?", ctx.RoomID, ctx.UserID).First(&member).Error; err != nil || !helper.HasPermission(member.Role, helper.PermissionEditTodos) {
+		return nil, &CommandError{Status: fiber.StatusForbidden, Message: "Forbidden"}
+	}
+
+	var todo models.Todo
+	if err := initialisers.DB.Where("id = ? AND room_id = ?", input.ID, ctx.RoomID).First(&todo).Error; err != nil {
+		return nil, &CommandError{Status: fiber.StatusNotFound, Message: "Record not found"}
+	}
+
+	acquired, err := acquireEditLock(ctx.conn, todo.ID)
+	if err != nil {
+		return nil, err
+	}
+	if !acquired {
+		holder, ok := h.EditingLock(ctx.RoomID, todo.ID)
+		if !ok {
+			holder = EditingEvent{TodoID: todo.ID}
+		}
+		ctx.conn.reply("editing_conflict", holder)
+		return nil, &CommandError{Status: fiber.StatusConflict, Message: "Todo is being edited by " + holder.Username}
+	}
+
+	ctx.conn.editing[todo.ID] = true
+	h.publish(Envelope{
+		Action:   ActionEditingStarted,
+		RoomID:   ctx.RoomID,
+		TodoID:   todo.ID,
+		UserID:   ctx.UserID,
+		Username: ctx.Username,
+	})
+
+	return nil, nil
+}
+
+func (h *RoomHub) stopEditing(ctx CommandContext, payload json.RawMessage) (interface{}, error) {
+	var input struct {
+		ID uint `json:"id"`
+	}
+	if err := json.Unmarshal(payload, &input); err != nil {
+		return nil, &CommandError{Status: fiber.StatusBadRequest, Message: "Failed to parse command payload"}
+	}
+
+	if !ctx.conn.editing[input.ID] {
+		return nil, nil
+	}
+
+	delete(ctx.conn.editing, input.ID)
+	released, err := releaseEditLock(ctx.conn, input.ID)
+	if err != nil {
+		return nil, err
+	}
+	if released {
+		h.publishEditingStopped(ctx.conn, input.ID)
+	}
+	return nil, nil
+}
+
+func (h *RoomHub) publishEditingStopped(conn *Connection, todoID uint) {
+	h.publish(Envelope{
+		Action:   ActionEditingStopped,
+		RoomID:   conn.RoomId,
+		TodoID:   todoID,
+		UserID:   conn.UserID,
+		Username: conn.Username,
+	})
+}
+
+// releaseEditing drops the locks a closing connection still holds. Locks the
+// user's other tabs took over stay with them.
+func (h *RoomHub) releaseEditing(conn *Connection) {
+	for todoID := range conn.editing {
+		released, err := releaseEditLock(conn, todoID)
+		if err != nil {
+			log.Printf("Error releasing editing lock of todo %d: %v", todoID, err)
+			continue
+		}
+		if released {
+			h.publishEditingStopped(conn, todoID)
+		}
+	}
+	conn.editing = nil
+}
+
+// applyEditing tells this instance's clients in the room that a lock was
+// taken or released
+func (h *RoomHub) applyEditing(envelope Envelope, started bool) {
+	messageType := "editing_stopped"
+	if started {
+		messageType = "editing_started"
+	}
+
+	event := EditingEvent{TodoID: envelope.TodoID, UserID: envelope.UserID, Username: envelope.Username}
+	h.broadcast(envelope.RoomID, messageType, event, false, func(*Connection) bool {
+		return true
+	})
+}
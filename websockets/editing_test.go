@@ -0,0 +1,113 @@
+package websockets
+
+import (
+	"realtime-todos/initialisers"
+	"realtime-todos/models"
+	"sync"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// useLockDatabase points initialisers.DB at an in-memory database holding
+// only the editing locks
+func useLockDatabase(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+
+	// Every connection to :memory: is a separate database
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("getting database pool: %v", err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+
+	if err := db.AutoMigrate(&models.EditLock{}); err != nil {
+		t.Fatalf("migrating: %v", err)
+	}
+
+	previous := initialisers.DB
+	initialisers.DB = db
+	t.Cleanup(func() { initialisers.DB = previous })
+	return db
+}
+
+func TestEditLockGoesToOneConnection(t *testing.T) {
+	useLockDatabase(t)
+	h := newTestHub(t)
+
+	alice := newConnection(nil, 1, 7, "alice", h.config)
+	bob := newConnection(nil, 1, 8, "bob", h.config)
+
+	var wg sync.WaitGroup
+	acquired := make([]bool, 2)
+	for i, conn := range []*Connection{alice, bob} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			ok, err := acquireEditLock(conn, 3)
+			if err != nil {
+				t.Errorf("acquiring lock: %v", err)
+			}
+			acquired[i] = ok
+		}()
+	}
+	wg.Wait()
+
+	if acquired[0] == acquired[1] {
+		t.Fatalf("got acquired %v, want exactly one connection to get the lock", acquired)
+	}
+
+	holder, ok := h.EditingLock(1, 3)
+	winner := alice
+	if acquired[1] {
+		winner = bob
+	}
+	if !ok || holder.UserID != winner.UserID {
+		t.Errorf("got holder %+v, want user %d", holder, winner.UserID)
+	}
+}
+
+func TestEditLockStaysWithTheTabHoldingIt(t *testing.T) {
+	useLockDatabase(t)
+	h := newTestHub(t)
+
+	firstTab := newConnection(nil, 1, 7, "alice", h.config)
+	secondTab := newConnection(nil, 1, 7, "alice", h.config)
+
+	if ok, err := acquireEditLock(firstTab, 3); !ok || err != nil {
+		t.Fatalf("got %v, %v, want the lock", ok, err)
+	}
+	firstTab.editing[3] = true
+
+	// Closing a tab that never took the lock leaves it alone
+	h.releaseEditing(secondTab)
+	if _, ok := h.EditingLock(1, 3); !ok {
+		t.Fatal("lock was released by the other tab")
+	}
+
+	// The user's other tab can take it over, after which the first tab's
+	// leftovers do not release it either
+	if ok, err := acquireEditLock(secondTab, 3); !ok || err != nil {
+		t.Fatalf("got %v, %v, want the lock moved to the second tab", ok, err)
+	}
+	h.releaseEditing(firstTab)
+	if _, ok := h.EditingLock(1, 3); !ok {
+		t.Fatal("lock was released by the tab that lost it")
+	}
+
+	if released, err := releaseEditLock(secondTab, 3); !released || err != nil {
+		t.Fatalf("got %v, %v, want the lock released", released, err)
+	}
+	if _, ok := h.EditingLock(1, 3); ok {
+		t.Error("lock is still held")
+	}
+}
@@ -0,0 +1,62 @@
+package websockets
+
+// eventLogSize is how many recent events are kept per room for replay
+const eventLogSize = 256
+
+// loggedEvent is a broadcast message as it was sent, with its sequence number
+type loggedEvent struct {
+	seq         uint64
+	messageType string
+	data        []byte
+	// publicData is what public viewers were sent instead of data, if anything
+	publicData []byte
+}
+
+// dataFor returns the message as the connection was sent it
+func (e loggedEvent) dataFor(conn *Connection) []byte {
+	if conn.Public && e.publicData != nil {
+		return e.publicData
+	}
+	return e.data
+}
+
+// eventLog numbers a room's events and keeps the most recent ones so that
+// reconnecting clients can catch up on what they missed
+type eventLog struct {
+	// seq is the sequence number of the last event, 0 before any event
+	seq    uint64
+	events []loggedEvent
+}
+
+// next returns the sequence number for the next event
+func (l *eventLog) next() uint64 {
+	l.seq++
+	return l.seq
+}
+
+// append records an event, dropping the oldest one once the log is full
+func (l *eventLog) append(seq uint64, messageType string, data []byte, publicData []byte) {
+	if len(l.events) == eventLogSize {
+		copy(l.events, l.events[1:])
+		l.events = l.events[:eventLogSize-1]
+	}
+	l.events = append(l.events, loggedEvent{seq: seq, messageType: messageType, data: data, publicData: publicData})
+}
+
+// since returns the events after seq. It reports false when some of those
+// events are no longer in the log, or when seq is from a log this process
+// never had (e.g. before a restart), in which case the client must refetch.
+func (l *eventLog) since(seq uint64) ([]loggedEvent, bool) {
+	if seq > l.seq {
+		return nil, false
+	}
+	if seq == l.seq {
+		return nil, true
+	}
+	if len(l.events) == 0 || l.events[0].seq > seq+1 {
+		return nil, false
+	}
+
+	start := int(seq + 1 - l.events[0].seq)
+	return l.events[start:], true
+}
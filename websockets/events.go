@@ -0,0 +1,215 @@
+package websockets
+
+import (
+	"log"
+	"realtime-todos/initialisers"
+	"realtime-todos/models"
+)
+
+// Event protocol versions. Version 1 clients receive the whole room on every
+// change, version 2 clients receive events carrying only the affected entity.
+const (
+	ProtocolV1 = 1
+	ProtocolV2 = 2
+)
+
+// TodoUpdatedEvent carries only the fields of a todo that changed, keyed by
+// their JSON names
+type TodoUpdatedEvent struct {
+	ID      uint                   `json:"id"`
+	Changes map[string]interface{} `json:"changes"`
+}
+
+type TodoDeletedEvent struct {
+	ID uint `json:"id"`
+}
+
+// TodosReorderedEvent lists the reordered todo IDs in their new order
+type TodosReorderedEvent struct {
+	IDs []uint `json:"ids"`
+}
+
+type RoomRenamedEvent struct {
+	ID   uint   `json:"id"`
+	Name string `json:"name"`
+}
+
+type RoomDeletedEvent struct {
+	ID uint `json:"id"`
+}
+
+type RoomRemovedEvent struct {
+	ID uint `json:"id"`
+}
+
+// broadcastLegacyRoom asks every instance to send the reloaded room to its
+// version 1 clients
+func broadcastLegacyRoom(roomID uint, messageType string) {
+	Hub.publish(Envelope{Action: ActionLegacyRoom, RoomID: roomID, Type: messageType})
+}
+
+// sendLegacyRoom reloads the room and sends it to this instance's version 1
+// clients. The query is skipped entirely when none of them are in the room.
+func sendLegacyRoom(roomID uint, messageType string) {
+	if !Hub.hasProtocol(roomID, ProtocolV1) {
+		return
+	}
+
+	query := initialisers.DB.Preload("Users.Todos", "room_id = ?", roomID)
+	if messageType == "room_deleted" {
+		// The room is soft-deleted by now, its members are sent without todos
+		query = initialisers.DB.Unscoped().Preload("Users").Preload("Members")
+	}
+
+	var room models.Room
+	if err := query.Where("id = ?", roomID).First(&room).Error; err != nil {
+		log.Printf("Error loading room %d for %s: %v", roomID, messageType, err)
+		return
+	}
+
+	Hub.broadcast(roomID, messageType, room, false, func(conn *Connection) bool {
+		return conn.Protocol == ProtocolV1
+	})
+}
+
+// BroadcastTodoCreated announces a todo created by creator. Public viewers
+// get it as a PublicTodo, like GetPublicRoom serves it.
+func BroadcastTodoCreated(room models.Room, todo models.Todo, creator string) {
+	Hub.BroadcastWithPublic(room.ID, ProtocolV2, "todo_created", todo, NewPublicTodo(room, todo, creator))
+	broadcastLegacyRoom(room.ID, "todos_updated")
+}
+
+func BroadcastTodoUpdated(roomID uint, todoID uint, changes map[string]interface{}) {
+	Hub.BroadcastToProtocol(roomID, ProtocolV2, "todo_updated", TodoUpdatedEvent{ID: todoID, Changes: changes})
+	broadcastLegacyRoom(roomID, "todos_updated")
+}
+
+func BroadcastTodoDeleted(roomID uint, todoID uint) {
+	Hub.BroadcastToProtocol(roomID, ProtocolV2, "todo_deleted", TodoDeletedEvent{ID: todoID})
+	broadcastLegacyRoom(roomID, "todos_updated")
+}
+
+func BroadcastTodosReordered(roomID uint, ids []uint) {
+	Hub.BroadcastToProtocol(roomID, ProtocolV2, "todos_reordered", TodosReorderedEvent{IDs: ids})
+	broadcastLegacyRoom(roomID, "todos_updated")
+}
+
+func BroadcastUserJoined(roomID uint, user models.User) {
+	Hub.BroadcastToProtocol(roomID, ProtocolV2, "user_joined", user)
+	broadcastLegacyRoom(roomID, "user_joined")
+}
+
+func BroadcastUserLeft(roomID uint, user models.User) {
+	Hub.BroadcastToProtocol(roomID, ProtocolV2, "user_left", user)
+	broadcastLegacyRoom(roomID, "user_left")
+}
+
+func BroadcastRoomNameUpdated(room models.Room) {
+	Hub.BroadcastToProtocol(room.ID, ProtocolV2, "room_name_updated", RoomRenamedEvent{ID: room.ID, Name: room.Name})
+	broadcastLegacyRoom(room.ID, "room_name_updated")
+}
+
+func BroadcastRoomDeleted(room models.Room) {
+	Hub.BroadcastToProtocol(room.ID, ProtocolV2, "room_deleted", RoomDeletedEvent{ID: room.ID})
+	broadcastLegacyRoom(room.ID, "room_deleted")
+	Hub.CloseRoom(room.ID)
+}
+
+// RoomAdminChangedEvent names the room's new admin and the one it replaced
+type RoomAdminChangedEvent struct {
+	ID              uint   `json:"id"`
+	AdminID         uint   `json:"adminId"`
+	Admin           string `json:"admin"`
+	PreviousAdminID uint   `json:"previousAdminId"`
+}
+
+// BroadcastRoomAdminChanged announces room.Admin as the new admin
+func BroadcastRoomAdminChanged(room models.Room, previousAdminID uint) {
+	Hub.BroadcastToProtocol(room.ID, ProtocolV2, "room_admin_changed", RoomAdminChangedEvent{
+		ID:              room.ID,
+		AdminID:         room.AdminID,
+		Admin:           room.Admin.Username,
+		PreviousAdminID: previousAdminID,
+	})
+	broadcastLegacyRoom(room.ID, "room_admin_changed")
+}
+
+// Dashboard events delivered on the user channel
+
+// NotifyAddedToRoom puts the room on the user's dashboard. Only the IDs travel
+// through pub/sub, rooms with many members would not fit in a NOTIFY payload.
+func NotifyAddedToRoom(userID uint, room models.Room) {
+	Hub.publish(Envelope{Action: ActionAddedToRoom, RoomID: room.ID, UserID: userID})
+}
+
+// sendAddedToRoom reloads the room for this instance's user channels of the
+// user, skipping the query when there are none
+func sendAddedToRoom(userID uint, roomID uint) {
+	if !Hub.hasUserChannel(userID) {
+		return
+	}
+
+	var room models.Room
+	if err := initialisers.DB.Preload("Users").Preload("Admin").Preload("Members").Where("id = ?", roomID).First(&room).Error; err != nil {
+		log.Printf("Error loading room %d for added_to_room: %v", roomID, err)
+		return
+	}
+
+	Hub.sendToUser(userID, "added_to_room", room)
+}
+
+func NotifyRemovedFromRoom(userID uint, room models.Room) {
+	Hub.NotifyUser(userID, "removed_from_room", RoomRemovedEvent{ID: room.ID})
+}
+
+// NotifyRoomRenamed tells every member in room.Users about the new name
+func NotifyRoomRenamed(room models.Room) {
+	for _, user := range room.Users {
+		Hub.NotifyUser(user.ID, "room_renamed", RoomRenamedEvent{ID: room.ID, Name: room.Name})
+	}
+}
+
+// NotifyRoomDeleted tells every member in room.Users that the room is gone
+func NotifyRoomDeleted(room models.Room) {
+	for _, user := range room.Users {
+		Hub.NotifyUser(user.ID, "room_deleted", RoomDeletedEvent{ID: room.ID})
+	}
+}
+
+// NotifyRoomAdminChanged tells every member in room.Users about the new admin
+func NotifyRoomAdminChanged(room models.Room, previousAdminID uint) {
+	for _, user := range room.Users {
+		Hub.NotifyUser(user.ID, "room_admin_changed", RoomAdminChangedEvent{
+			ID:              room.ID,
+			AdminID:         room.AdminID,
+			Admin:           room.Admin.Username,
+			PreviousAdminID: previousAdminID,
+		})
+	}
+}
+
+// InvitationEvent identifies an invitation whose state changed
+type InvitationEvent struct {
+	ID     uint   `json:"id"`
+	RoomID uint   `json:"roomId"`
+	Status string `json:"status,omitempty"`
+}
+
+// NotifyInvitationReceived shows the invitation to the invitee as it arrives
+func NotifyInvitationReceived(invitation models.Invitation) {
+	Hub.NotifyUser(invitation.InviteeID, "invitation_received", invitation)
+}
+
+// NotifyInvitationRevoked withdraws the invitation from the invitee's list
+func NotifyInvitationRevoked(invitation models.Invitation) {
+	Hub.NotifyUser(invitation.InviteeID, "invitation_revoked", InvitationEvent{ID: invitation.ID, RoomID: invitation.RoomID})
+}
+
+// NotifyInvitationAnswered tells the inviter whether the invitation was accepted or declined
+func NotifyInvitationAnswered(invitation models.Invitation) {
+	Hub.NotifyUser(invitation.InviterID, "invitation_answered", InvitationEvent{ID: invitation.ID, RoomID: invitation.RoomID, Status: invitation.Status})
+}
+
+func BroadcastMemberRoleUpdated(roomID uint, member models.RoomUser) {
+	Hub.BroadcastToRoom(roomID, "member_role_updated", member)
+}
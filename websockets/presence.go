@@ -0,0 +1,269 @@
+package websockets
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+)
+
+// presenceHeartbeatInterval is how often every instance republishes the
+// presence of its sockets, which keeps it from expiring elsewhere
+const presenceHeartbeatInterval = 10 * time.Second
+
+// presenceTTL is how long a count survives without being republished. It
+// drops the users of an instance that crashed without saying goodbye.
+const presenceTTL = 3 * presenceHeartbeatInterval
+
+// presenceStateBatch caps the users per state envelope, so it stays under the
+// 8000 byte limit of a Postgres NOTIFY payload
+const presenceStateBatch = 25
+
+// PresenceUser is a user with at least one socket open in a room
+type PresenceUser struct {
+	UserID   uint   `json:"userId"`
+	Username string `json:"username"`
+}
+
+// PresenceSnapshotEvent lists everyone online, sent to a client when it connects
+type PresenceSnapshotEvent struct {
+	Users []PresenceUser `json:"users"`
+}
+
+// PresenceCount is how many sockets a user has open in a room on one instance
+type PresenceCount struct {
+	UserID   uint   `json:"userId"`
+	Username string `json:"username"`
+	Sockets  int    `json:"sockets"`
+}
+
+// presenceEntry counts a user's open sockets in a room per instance, so
+// several tabs, possibly on different instances, count as one presence.
+// Instances publish absolute counts, each kept until presenceTTL passes
+// without it being published again.
+type presenceEntry struct {
+	username string
+	sockets  map[string]presenceCount
+}
+
+type presenceCount struct {
+	sockets int
+	seenAt  time.Time
+}
+
+func (e *presenceEntry) total() int {
+	total := 0
+	for _, count := range e.sockets {
+		total += count.sockets
+	}
+	return total
+}
+
+// StartPresence asks the other instances for their presence, so this one
+// starts with the full picture, and keeps republishing its own until Shutdown
+func (h *RoomHub) StartPresence() {
+	h.requestPresenceSync()
+
+	go func() {
+		ticker := time.NewTicker(presenceHeartbeatInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-h.presenceStop:
+				return
+			case now := <-ticker.C:
+				h.publishPresenceState()
+				h.expirePresence(now)
+			}
+		}
+	}()
+}
+
+// stopPresence ends the heartbeat started by StartPresence
+func (h *RoomHub) stopPresence() {
+	h.presenceStopOnce.Do(func() {
+		close(h.presenceStop)
+	})
+}
+
+// requestPresenceSync asks every instance to publish its presence right away
+func (h *RoomHub) requestPresenceSync() {
+	h.publish(Envelope{Action: ActionPresenceSync})
+}
+
+// publishPresence counts a socket this instance opened (delta 1) or closed
+// (delta -1) for the connection's user and publishes the user's new count.
+// Publishing under localMu keeps the counts of one user in order.
+func (h *RoomHub) publishPresence(conn *Connection, delta int) {
+	h.localMu.Lock()
+	defer h.localMu.Unlock()
+
+	users, ok := h.localPresence[conn.RoomId]
+	if !ok {
+		users = make(map[uint]PresenceCount)
+		h.localPresence[conn.RoomId] = users
+	}
+	count := users[conn.UserID]
+	count.UserID = conn.UserID
+	count.Username = conn.Username
+	count.Sockets += delta
+	if count.Sockets > 0 {
+		users[conn.UserID] = count
+	} else {
+		delete(users, conn.UserID)
+		if len(users) == 0 {
+			delete(h.localPresence, conn.RoomId)
+		}
+	}
+
+	h.publish(Envelope{
+		Action:   ActionPresence,
+		RoomID:   conn.RoomId,
+		Epoch:    h.instance,
+		Presence: []PresenceCount{count},
+	})
+}
+
+// publishPresenceState republishes the counts of every socket this instance
+// has open, room by room
+func (h *RoomHub) publishPresenceState() {
+	h.localMu.Lock()
+	defer h.localMu.Unlock()
+
+	for roomId, users := range h.localPresence {
+		counts := make([]PresenceCount, 0, len(users))
+		for _, count := range users {
+			counts = append(counts, count)
+		}
+
+		for start := 0; start < len(counts); start += presenceStateBatch {
+			end := min(start+presenceStateBatch, len(counts))
+			h.publish(Envelope{
+				Action:   ActionPresence,
+				RoomID:   roomId,
+				Epoch:    h.instance,
+				Presence: counts[start:end],
+			})
+		}
+	}
+}
+
+// applyPresence records the counts an instance published for a room and tells
+// this instance's clients when a user came online with their first socket or
+// went offline with their last
+func (h *RoomHub) applyPresence(envelope Envelope) {
+	now := time.Now()
+
+	h.presenceMu.Lock()
+	users, ok := h.presence[envelope.RoomID]
+	if !ok {
+		users = make(map[uint]*presenceEntry)
+		h.presence[envelope.RoomID] = users
+	}
+
+	var joined, left []PresenceUser
+	for _, count := range envelope.Presence {
+		entry, ok := users[count.UserID]
+		if !ok {
+			entry = &presenceEntry{username: count.Username, sockets: make(map[string]presenceCount)}
+			users[count.UserID] = entry
+		}
+
+		before := entry.total()
+		if count.Sockets > 0 {
+			entry.sockets[envelope.Epoch] = presenceCount{sockets: count.Sockets, seenAt: now}
+		} else {
+			delete(entry.sockets, envelope.Epoch)
+		}
+		after := entry.total()
+
+		user := PresenceUser{UserID: count.UserID, Username: entry.username}
+		switch {
+		case before == 0 && after > 0:
+			joined = append(joined, user)
+		case before > 0 && after == 0:
+			left = append(left, user)
+		}
+		if after == 0 {
+			delete(users, count.UserID)
+		}
+	}
+	if len(users) == 0 {
+		delete(h.presence, envelope.RoomID)
+	}
+	h.presenceMu.Unlock()
+
+	h.announcePresence(envelope.RoomID, joined, left)
+}
+
+// expirePresence drops the counts that were not republished within
+// presenceTTL, as the instance that held the sockets is gone
+func (h *RoomHub) expirePresence(now time.Time) {
+	left := make(map[uint][]PresenceUser)
+
+	h.presenceMu.Lock()
+	for roomId, users := range h.presence {
+		for userID, entry := range users {
+			for instance, count := range entry.sockets {
+				if now.Sub(count.seenAt) > presenceTTL {
+					delete(entry.sockets, instance)
+				}
+			}
+			if entry.total() == 0 {
+				delete(users, userID)
+				left[roomId] = append(left[roomId], PresenceUser{UserID: userID, Username: entry.username})
+			}
+		}
+		if len(users) == 0 {
+			delete(h.presence, roomId)
+		}
+	}
+	h.presenceMu.Unlock()
+
+	for roomId, users := range left {
+		h.announcePresence(roomId, nil, users)
+	}
+}
+
+// announcePresence sends presence_joined and presence_left to this instance's
+// clients in the room
+func (h *RoomHub) announcePresence(roomId uint, joined []PresenceUser, left []PresenceUser) {
+	for _, user := range joined {
+		h.broadcast(roomId, "presence_joined", user, false, func(*Connection) bool {
+			return true
+		})
+	}
+	for _, user := range left {
+		h.broadcast(roomId, "presence_left", user, false, func(*Connection) bool {
+			return true
+		})
+	}
+}
+
+// Presence returns the users currently online in a room, sorted by username
+func (h *RoomHub) Presence(roomId uint) []PresenceUser {
+	h.presenceMu.Lock()
+	defer h.presenceMu.Unlock()
+
+	users := make([]PresenceUser, 0, len(h.presence[roomId]))
+	for userID, entry := range h.presence[roomId] {
+		users = append(users, PresenceUser{UserID: userID, Username: entry.username})
+	}
+
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].Username < users[j].Username
+	})
+	return users
+}
+
+// sendPresenceSnapshot queues the current presence of the room for a new connection
+func (h *RoomHub) sendPresenceSnapshot(conn *Connection) {
+	data, err := json.Marshal(Message{
+		Type:    "presence_snapshot",
+		Payload: PresenceSnapshotEvent{Users: h.Presence(conn.RoomId)},
+	})
+	if err != nil {
+		return
+	}
+	conn.enqueue(data)
+}
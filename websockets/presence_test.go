@@ -0,0 +1,117 @@
+package websockets
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestPresenceOfVanishedInstanceExpires(t *testing.T) {
+	h := newTestHub(t)
+
+	viewer := newConnection(nil, 1, 7, "alice", h.config)
+	h.connections[1] = []*Connection{viewer}
+
+	h.deliver(Envelope{
+		Action:   ActionPresence,
+		RoomID:   1,
+		Epoch:    "crashed",
+		Presence: []PresenceCount{{UserID: 8, Username: "bob", Sockets: 2}},
+	})
+	if got := h.Presence(1); len(got) != 1 || got[0].Username != "bob" {
+		t.Fatalf("got presence %v, want bob", got)
+	}
+	if got := messageType(t, viewer); got != "presence_joined" {
+		t.Errorf("got %s, want presence_joined", got)
+	}
+
+	h.expirePresence(time.Now().Add(presenceTTL / 2))
+	if got := h.Presence(1); len(got) != 1 {
+		t.Fatalf("got presence %v before the TTL passed, want bob", got)
+	}
+
+	h.expirePresence(time.Now().Add(presenceTTL + time.Second))
+	if got := h.Presence(1); len(got) != 0 {
+		t.Errorf("got presence %v after the TTL passed, want nobody", got)
+	}
+	if got := messageType(t, viewer); got != "presence_left" {
+		t.Errorf("got %s, want presence_left", got)
+	}
+}
+
+func TestPresenceSyncRepublishesLocalSockets(t *testing.T) {
+	h := newTestHub(t)
+
+	first := newConnection(nil, 1, 7, "alice", h.config)
+	second := newConnection(nil, 1, 7, "alice", h.config)
+	h.publishPresence(first, 1)
+	h.publishPresence(second, 1)
+
+	// A freshly started instance only knows what it is told after asking
+	h.presence = make(map[uint]map[uint]*presenceEntry)
+	h.deliver(Envelope{Action: ActionPresenceSync})
+
+	entry := h.presence[1][7]
+	if entry == nil || entry.total() != 2 {
+		t.Fatalf("got %+v, want alice with 2 sockets", entry)
+	}
+
+	h.publishPresence(second, -1)
+	h.publishPresence(first, -1)
+	if got := h.Presence(1); len(got) != 0 {
+		t.Errorf("got presence %v after every socket closed, want nobody", got)
+	}
+}
+
+func TestPresenceCountsSocketsPerInstance(t *testing.T) {
+	h := newTestHub(t)
+
+	for _, instance := range []string{"a", "b"} {
+		h.deliver(Envelope{
+			Action:   ActionPresence,
+			RoomID:   1,
+			Epoch:    instance,
+			Presence: []PresenceCount{{UserID: 7, Username: "alice", Sockets: 1}},
+		})
+	}
+
+	// Republishing the same count does not add up
+	h.deliver(Envelope{
+		Action:   ActionPresence,
+		RoomID:   1,
+		Epoch:    "a",
+		Presence: []PresenceCount{{UserID: 7, Username: "alice", Sockets: 1}},
+	})
+	if got := h.presence[1][7].total(); got != 2 {
+		t.Fatalf("got %d sockets, want 2", got)
+	}
+
+	h.deliver(Envelope{
+		Action:   ActionPresence,
+		RoomID:   1,
+		Epoch:    "a",
+		Presence: []PresenceCount{{UserID: 7, Username: "alice", Sockets: 0}},
+	})
+	if got := h.Presence(1); len(got) != 1 {
+		t.Errorf("got presence %v, want alice still online through instance b", got)
+	}
+}
+
+// messageType decodes the type of the next queued message of the connection
+func messageType(t *testing.T, conn *Connection) string {
+	t.Helper()
+
+	select {
+	case data := <-conn.send:
+		var message struct {
+			Type string `json:"type"`
+		}
+		if err := json.Unmarshal(data, &message); err != nil {
+			t.Fatalf("decoding message: %v", err)
+		}
+		return message.Type
+	default:
+		t.Fatal("no message queued")
+		return ""
+	}
+}
@@ -0,0 +1,72 @@
+package websockets
+
+import (
+	"realtime-todos/helper"
+	"realtime-todos/initialisers"
+	"realtime-todos/models"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// publicEvents are the events public viewers receive. Everything else names
+// members (presence, editing, membership) and stays private.
+var publicEvents = map[string]bool{
+	"todo_created":      true,
+	"todo_updated":      true,
+	"todo_deleted":      true,
+	"todos_reordered":   true,
+	"room_name_updated": true,
+	"room_deleted":      true,
+}
+
+// PublicTodo is a todo as public viewers see it, over REST and the live feed
+type PublicTodo struct {
+	ID          uint      `json:"id"`
+	Title       string    `json:"title"`
+	IsCompleted bool      `json:"isCompleted"`
+	Order       uint      `json:"order"`
+	CreatedAt   time.Time `json:"createdAt"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+	// Username is only filled in when the room opted into showing usernames
+	Username string `json:"username,omitempty"`
+}
+
+// NewPublicTodo strips the todo down for public viewers of the room, naming
+// its creator only if the room shows usernames
+func NewPublicTodo(room models.Room, todo models.Todo, creator string) PublicTodo {
+	item := PublicTodo{
+		ID:          todo.ID,
+		Title:       todo.Title,
+		IsCompleted: todo.IsCompleted,
+		Order:       todo.Order,
+		CreatedAt:   todo.CreatedAt,
+		UpdatedAt:   todo.UpdatedAt,
+	}
+	if room.PublicUsernames {
+		item.Username = creator
+	}
+	return item
+}
+
+// AuthorizePublicRoom runs before a public viewer's upgrade or event stream
+// and resolves the share token in the :token param to its room
+func (h *RoomHub) AuthorizePublicRoom(c *fiber.Ctx) error {
+	var room models.Room
+	if err := initialisers.DB.Where("public_token_hash = ?", helper.HashToken(c.Params("token"))).First(&room).Error; err != nil {
+		return helper.HandleError(c, err)
+	}
+
+	c.Locals("roomID", room.ID)
+	c.Locals("public", true)
+	return c.Next()
+}
+
+// ClosePublicViewers disconnects the room's public viewers on every instance,
+// after its share link was revoked or replaced
+func (h *RoomHub) ClosePublicViewers(roomId uint) {
+	h.publish(Envelope{
+		Action: ActionClosePublic,
+		RoomID: roomId,
+	})
+}
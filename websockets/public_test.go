@@ -0,0 +1,112 @@
+package websockets
+
+import (
+	"encoding/json"
+	"realtime-todos/models"
+	"testing"
+)
+
+// newTestHub returns an empty hub delivering through an in-memory pub/sub
+func newTestHub(t *testing.T) *RoomHub {
+	t.Helper()
+
+	h := &RoomHub{
+		connections:   make(map[uint][]*Connection),
+		users:         make(map[uint][]*Connection),
+		logs:          make(map[uint]*eventLog),
+		presence:      make(map[uint]map[uint]*presenceEntry),
+		localPresence: make(map[uint]map[uint]PresenceCount),
+		presenceStop:  make(chan struct{}),
+		commands:      commandRegistry{handlers: make(map[string]CommandHandler)},
+		epoch:         newEpoch(),
+		instance:      newEpoch(),
+		config:        DefaultHubConfig(),
+	}
+	if err := h.UsePubSub(NewMemoryPubSub()); err != nil {
+		t.Fatalf("using pub/sub: %v", err)
+	}
+	return h
+}
+
+// received decodes the next queued message of the connection
+func received(t *testing.T, conn *Connection) map[string]interface{} {
+	t.Helper()
+
+	select {
+	case data := <-conn.send:
+		var message struct {
+			Type    string                 `json:"type"`
+			Payload map[string]interface{} `json:"payload"`
+		}
+		if err := json.Unmarshal(data, &message); err != nil {
+			t.Fatalf("decoding message: %v", err)
+		}
+		return message.Payload
+	default:
+		t.Fatal("no message queued")
+		return nil
+	}
+}
+
+func TestTodoCreatedIsFilteredForPublicViewers(t *testing.T) {
+	todo := models.Todo{RoomID: 1, UserID: 7, Title: "Plan", Order: 2}
+	todo.ID = 3
+
+	tests := []struct {
+		name          string
+		showUsernames bool
+		wantUsername  interface{}
+	}{
+		{"usernames hidden", false, nil},
+		{"usernames shown", true, "alice"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHub(t)
+
+			member := newConnection(nil, 1, 7, "alice", h.config)
+			member.Protocol = ProtocolV2
+			viewer := newConnection(nil, 1, 0, "", h.config)
+			viewer.Protocol = ProtocolV2
+			viewer.Public = true
+			h.connections[1] = []*Connection{member, viewer}
+
+			room := models.Room{PublicUsernames: tt.showUsernames}
+			room.ID = 1
+			h.BroadcastWithPublic(1, ProtocolV2, "todo_created", todo, NewPublicTodo(room, todo, "alice"))
+
+			if got := received(t, member)["userId"]; got != float64(7) {
+				t.Errorf("member got userId %v, want 7", got)
+			}
+
+			public := received(t, viewer)
+			for _, field := range []string{"userId", "user", "room"} {
+				if _, ok := public[field]; ok {
+					t.Errorf("public viewer got %q", field)
+				}
+			}
+			if public["title"] != "Plan" {
+				t.Errorf("public viewer got title %v, want Plan", public["title"])
+			}
+			if public["username"] != tt.wantUsername {
+				t.Errorf("public viewer got username %v, want %v", public["username"], tt.wantUsername)
+			}
+
+			// Resuming viewers are replayed the filtered event as well
+			events, ok := h.logs[1].since(0)
+			if !ok || len(events) != 1 {
+				t.Fatalf("got %d logged events, want 1", len(events))
+			}
+			var replayed struct {
+				Payload map[string]interface{} `json:"payload"`
+			}
+			if err := json.Unmarshal(events[0].dataFor(viewer), &replayed); err != nil {
+				t.Fatalf("decoding replay: %v", err)
+			}
+			if _, ok := replayed.Payload["userId"]; ok {
+				t.Error("public replay carries userId")
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package websockets
+
+import (
+	"encoding/json"
+	"sync"
+)
+
+// Envelope actions published through a PubSub backend
+const (
+	// ActionBroadcast sends Type and Payload to the matching connections
+	ActionBroadcast = "broadcast"
+	// ActionLegacyRoom asks every instance with protocol v1 clients in the
+	// room to reload it and send it to them as Type
+	ActionLegacyRoom = "legacy_room"
+	// ActionDisconnectUser closes every connection UserID has in the room
+	ActionDisconnectUser = "disconnect_user"
+	// ActionCloseRoom closes every connection in the room
+	ActionCloseRoom = "close_room"
+	// ActionClosePublic closes the room's public viewer connections
+	ActionClosePublic = "close_public"
+	// ActionPresence sets how many sockets the users in Presence have open
+	// in the room on the instance identified by Epoch, which carries the
+	// hub's instance ID
+	ActionPresence = "presence"
+	// ActionPresenceSync asks every instance to publish ActionPresence for
+	// all of its sockets
+	ActionPresenceSync = "presence_sync"
+	// ActionEditingStarted announces that UserID took the editing lock on TodoID
+	ActionEditingStarted = "editing_started"
+	// ActionEditingStopped announces that UserID's editing lock on TodoID was released
+	ActionEditingStopped = "editing_stopped"
+	// ActionNotifyUser sends Type and Payload to UserID's user channels
+	ActionNotifyUser = "notify_user"
+	// ActionAddedToRoom asks every instance with user channels of UserID to
+	// reload RoomID and send it to them as added_to_room
+	ActionAddedToRoom = "added_to_room"
+	// ActionCloseSessions closes every connection opened by one of SessionIDs
+	ActionCloseSessions = "close_sessions"
+	// ActionResync is never published. A backend hands it to its own
+	// subscriber after it may have missed envelopes, see RoomHub.resync.
+	ActionResync = "resync"
+)
+
+// Envelope is a hub operation as it travels between instances
+type Envelope struct {
+	Action string `json:"action"`
+	RoomID uint   `json:"roomId"`
+	// Protocol limits a broadcast to clients of one protocol version, 0 means all
+	Protocol int             `json:"protocol,omitempty"`
+	Type     string          `json:"type,omitempty"`
+	Payload  json.RawMessage `json:"payload,omitempty"`
+	UserID   uint            `json:"userId,omitempty"`
+	TodoID   uint            `json:"todoId,omitempty"`
+	Username string          `json:"username,omitempty"`
+	Epoch    string          `json:"epoch,omitempty"`
+
+	// PublicPayload replaces Payload for public viewers when set
+	PublicPayload json.RawMessage `json:"publicPayload,omitempty"`
+	SessionIDs    []string        `json:"sessionIds,omitempty"`
+	Presence      []PresenceCount `json:"presence,omitempty"`
+}
+
+// PubSub fans hub operations out to every instance of the server, including
+// the one that published them
+type PubSub interface {
+	// Publish sends the envelope to every subscriber
+	Publish(envelope Envelope) error
+	// Subscribe registers the handler that receives every published envelope
+	Subscribe(handler func(Envelope)) error
+	// Close stops delivering envelopes and releases the backend's resources
+	Close() error
+}
+
+// MemoryPubSub delivers envelopes synchronously within a single process
+type MemoryPubSub struct {
+	handler func(Envelope)
+	mu      sync.RWMutex
+}
+
+func NewMemoryPubSub() *MemoryPubSub {
+	return &MemoryPubSub{}
+}
+
+func (p *MemoryPubSub) Publish(envelope Envelope) error {
+	p.mu.RLock()
+	handler := p.handler
+	p.mu.RUnlock()
+
+	if handler != nil {
+		handler(envelope)
+	}
+	return nil
+}
+
+func (p *MemoryPubSub) Subscribe(handler func(Envelope)) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.handler = handler
+	return nil
+}
+
+func (p *MemoryPubSub) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.handler = nil
+	return nil
+}
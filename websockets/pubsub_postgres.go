@@ -0,0 +1,142 @@
+package websockets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"gorm.io/gorm"
+)
+
+// postgresChannel is the LISTEN/NOTIFY channel shared by every instance
+const postgresChannel = "room_events"
+
+// postgresMaxPayload is the largest payload NOTIFY accepts
+const postgresMaxPayload = 8000
+
+// postgresReconnectDelay is how long the listener waits before reconnecting
+const postgresReconnectDelay = 2 * time.Second
+
+// PostgresPubSub fans envelopes out to every instance with LISTEN/NOTIFY.
+// Publishing goes through the shared gorm pool, listening holds a dedicated
+// connection that is re-established whenever it drops.
+type PostgresPubSub struct {
+	dsn    string
+	db     *gorm.DB
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func NewPostgresPubSub(dsn string, db *gorm.DB) *PostgresPubSub {
+	return &PostgresPubSub{
+		dsn: dsn,
+		db:  db,
+	}
+}
+
+func (p *PostgresPubSub) Publish(envelope Envelope) error {
+	payload, err := json.Marshal(envelope)
+	if err != nil {
+		return err
+	}
+	if len(payload) >= postgresMaxPayload {
+		return fmt.Errorf("%s for room %d is %d bytes, NOTIFY allows less than %d", envelope.Type, envelope.RoomID, len(payload), postgresMaxPayload)
+	}
+
+	return p.db.Exec("SELECT pg_notify(?, ?)", postgresChannel, string(payload)).Error
+}
+
+// Subscribe connects the listener, so a bad DATABASE_URL fails here, and then
+// keeps delivering notifications in the background until Close
+func (p *PostgresPubSub) Subscribe(handler func(Envelope)) error {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	conn, err := p.listen(ctx)
+	if err != nil {
+		cancel()
+		return err
+	}
+
+	p.cancel = cancel
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		p.run(ctx, conn, handler)
+	}()
+
+	return nil
+}
+
+func (p *PostgresPubSub) Close() error {
+	if p.cancel != nil {
+		p.cancel()
+	}
+	p.wg.Wait()
+	return nil
+}
+
+func (p *PostgresPubSub) listen(ctx context.Context) (*pgx.Conn, error) {
+	conn, err := pgx.Connect(ctx, p.dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := conn.Exec(ctx, "LISTEN "+postgresChannel); err != nil {
+		conn.Close(context.Background())
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+func (p *PostgresPubSub) run(ctx context.Context, conn *pgx.Conn, handler func(Envelope)) {
+	for {
+		p.receive(ctx, conn, handler)
+		conn.Close(context.Background())
+
+		// Reconnect until it works or we are shutting down. Anything published
+		// in the meantime never reaches this instance, so the hub is told to
+		// start over once the listener is back.
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(postgresReconnectDelay):
+			}
+
+			var err error
+			conn, err = p.listen(ctx)
+			if err == nil {
+				log.Println("Room events listener reconnected")
+				break
+			}
+			log.Println("Error reconnecting room events listener:", err)
+		}
+
+		handler(Envelope{Action: ActionResync})
+	}
+}
+
+// receive hands notifications to the handler until the connection fails or ctx is cancelled
+func (p *PostgresPubSub) receive(ctx context.Context, conn *pgx.Conn, handler func(Envelope)) {
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Println("Room events listener error:", err)
+			}
+			return
+		}
+
+		var envelope Envelope
+		if err := json.Unmarshal([]byte(notification.Payload), &envelope); err != nil {
+			log.Println("Error decoding room event:", err)
+			continue
+		}
+		handler(envelope)
+	}
+}
@@ -0,0 +1,53 @@
+package websockets
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestResyncStartsNewEpochAndTellsV2Clients(t *testing.T) {
+	h := newTestHub(t)
+
+	v2 := newConnection(nil, 1, 7, "alice", h.config)
+	v2.Protocol = ProtocolV2
+	v1 := newConnection(nil, 1, 8, "bob", h.config)
+	v1.Protocol = ProtocolV1
+	h.connections[1] = []*Connection{v2, v1}
+
+	h.BroadcastToProtocol(1, ProtocolV2, "todo_created", map[string]string{"title": "Plan"})
+	<-v2.send
+	previous := h.epoch
+
+	h.deliver(Envelope{Action: ActionResync})
+
+	if h.epoch == previous {
+		t.Error("epoch did not change")
+	}
+	if len(h.logs) != 0 {
+		t.Errorf("got %d event logs, want none", len(h.logs))
+	}
+
+	select {
+	case data := <-v2.send:
+		var message struct {
+			Type    string              `json:"type"`
+			Payload ResyncRequiredEvent `json:"payload"`
+		}
+		if err := json.Unmarshal(data, &message); err != nil {
+			t.Fatalf("decoding message: %v", err)
+		}
+		if message.Type != "resync_required" || message.Payload.Epoch != h.epoch {
+			t.Errorf("got %s for epoch %q, want resync_required for %q", message.Type, message.Payload.Epoch, h.epoch)
+		}
+	default:
+		t.Error("v2 client was not told to resync")
+	}
+	if len(v1.send) != 0 {
+		t.Error("v1 client got a message")
+	}
+
+	// Resuming from the old epoch is refused
+	if events, ok := h.roomLog(1).since(1); ok {
+		t.Errorf("resumed %d events from the old epoch", len(events))
+	}
+}
@@ -0,0 +1,61 @@
+package websockets
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestCloseSessionsClosesEveryConnectionOfTheSessions(t *testing.T) {
+	h := newTestHub(t)
+
+	roomSocket := newConnection(nil, 1, 7, "alice", h.config)
+	roomSocket.SessionID = "revoked"
+	eventStream := newConnection(nil, 2, 7, "alice", h.config)
+	eventStream.SessionID = "revoked"
+	userChannel := newConnection(nil, 0, 7, "alice", h.config)
+	userChannel.SessionID = "revoked"
+	otherSession := newConnection(nil, 1, 7, "alice", h.config)
+	otherSession.SessionID = "active"
+	viewer := newConnection(nil, 1, 0, "", h.config)
+	viewer.Public = true
+
+	h.connections[1] = []*Connection{roomSocket, otherSession, viewer}
+	h.connections[2] = []*Connection{eventStream}
+	h.users[7] = []*Connection{userChannel}
+
+	h.CloseSessions([]string{"revoked"})
+
+	for name, conn := range map[string]*Connection{"room socket": roomSocket, "event stream": eventStream, "user channel": userChannel} {
+		if conn.closeCode != CloseSessionRevoked {
+			t.Errorf("%s got close code %d, want %d", name, conn.closeCode, CloseSessionRevoked)
+		}
+	}
+	for name, conn := range map[string]*Connection{"other session": otherSession, "public viewer": viewer} {
+		select {
+		case <-conn.done:
+			t.Errorf("%s was closed", name)
+		default:
+		}
+	}
+}
+
+func TestCloseSessionsBatchesLargeRevocations(t *testing.T) {
+	h := newTestHub(t)
+
+	ids := make([]string, 2*sessionBatchSize+1)
+	conns := make([]*Connection, len(ids))
+	for i := range ids {
+		ids[i] = fmt.Sprintf("session-%d", i)
+		conns[i] = newConnection(nil, 1, uint(i+1), "user", h.config)
+		conns[i].SessionID = ids[i]
+	}
+	h.connections[1] = conns
+
+	h.CloseSessions(ids)
+
+	for i, conn := range conns {
+		if conn.closeCode != CloseSessionRevoked {
+			t.Fatalf("connection of %s got close code %d, want %d", ids[i], conn.closeCode, CloseSessionRevoked)
+		}
+	}
+}
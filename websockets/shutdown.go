@@ -0,0 +1,66 @@
+package websockets
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/gofiber/contrib/websocket"
+)
+
+// shutdownPollInterval is how often Shutdown checks whether every connection is gone
+const shutdownPollInterval = 50 * time.Millisecond
+
+// Shutdown tells every client held by this instance that the server is
+// restarting, closes their connections with CloseServiceRestart and waits for
+// the handlers to finish or ctx to expire. The pub/sub backend is closed last.
+func (h *RoomHub) Shutdown(ctx context.Context) error {
+	h.stopPresence()
+	message, _ := json.Marshal(Message{Type: "server_restarting"})
+
+	h.mu.Lock()
+	h.shuttingDown = true
+	for _, conns := range h.connections {
+		for _, conn := range conns {
+			conn.enqueue(message)
+			conn.close(websocket.CloseServiceRestart, "server restarting")
+		}
+	}
+	for _, conns := range h.users {
+		for _, conn := range conns {
+			conn.enqueue(message)
+			conn.close(websocket.CloseServiceRestart, "server restarting")
+		}
+	}
+	h.mu.Unlock()
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+
+	for !h.drained() {
+		select {
+		case <-ctx.Done():
+			h.closePubSub()
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+
+	return h.closePubSub()
+}
+
+// drained reports whether every connection has been removed from the hub
+func (h *RoomHub) drained() bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return len(h.connections) == 0 && len(h.users) == 0
+}
+
+func (h *RoomHub) closePubSub() error {
+	h.mu.RLock()
+	pubsub := h.pubsub
+	h.mu.RUnlock()
+
+	return pubsub.Close()
+}
@@ -0,0 +1,138 @@
+package websockets
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// CloseEvent is the last event of a stream the server ends, mirroring the
+// close frame a socket would receive
+type CloseEvent struct {
+	Code   int    `json:"code"`
+	Reason string `json:"reason"`
+}
+
+// parseEventID splits a Last-Event-ID of the form "<epoch>:<seq>"
+func parseEventID(value string) (string, *uint64) {
+	epoch, seqValue, ok := strings.Cut(value, ":")
+	if !ok {
+		return "", nil
+	}
+
+	seq, err := strconv.ParseUint(seqValue, 10, 64)
+	if err != nil {
+		return "", nil
+	}
+	return epoch, &seq
+}
+
+// HandleEventStream serves the room's protocol v2 events as Server-Sent Events
+// for clients that cannot open a WebSocket. It must run after AuthorizeRoom.
+// Resuming works through the Last-Event-ID header, or ?lastEventId= for the
+// first request of a new EventSource.
+func (h *RoomHub) HandleEventStream(c *fiber.Ctx) error {
+	userID, _ := c.Locals("userID").(uint)
+	roomID, _ := c.Locals("roomID").(uint)
+	username, _ := c.Locals("username").(string)
+	public, _ := c.Locals("public").(bool)
+	sessionID, _ := c.Locals("sessionID").(string)
+
+	if roomID == 0 || (!public && (userID == 0 || username == "")) {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Unauthorized",
+		})
+	}
+
+	lastEventID := c.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = c.Query("lastEventId")
+	}
+	epoch, since := parseEventID(lastEventID)
+
+	h.mu.RLock()
+	conn := newConnection(nil, roomID, userID, username, h.config)
+	h.mu.RUnlock()
+	conn.Protocol = ProtocolV2
+	conn.Public = public
+	conn.SessionID = sessionID
+
+	c.Set(fiber.HeaderContentType, "text/event-stream")
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	c.Set(fiber.HeaderConnection, "keep-alive")
+	c.Set("X-Accel-Buffering", "no")
+
+	h.attach(conn, epoch, since)
+
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer func() {
+			h.detach(conn)
+			close(conn.stopped)
+		}()
+
+		conn.streamEvents(w, h.currentEpoch)
+	})
+
+	return nil
+}
+
+// streamEvents writes queued messages to the stream until the connection is
+// closed or the client goes away, with a comment line every PingInterval to
+// keep proxies from timing the stream out
+func (conn *Connection) streamEvents(w *bufio.Writer, epoch func() string) {
+	ticker := time.NewTicker(conn.config.PingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case message := <-conn.send:
+			if err := writeEvent(w, epoch(), message); err != nil {
+				return
+			}
+		case <-ticker.C:
+			if _, err := w.WriteString(": ping\n\n"); err != nil {
+				return
+			}
+			if err := w.Flush(); err != nil {
+				return
+			}
+		case <-conn.done:
+			if conn.flushOnClose {
+				for len(conn.send) > 0 {
+					if err := writeEvent(w, epoch(), <-conn.send); err != nil {
+						return
+					}
+				}
+			}
+
+			data, _ := json.Marshal(Message{Type: "close", Payload: CloseEvent{Code: conn.closeCode, Reason: conn.closeReason}})
+			writeEvent(w, epoch(), data)
+			return
+		}
+	}
+}
+
+// writeEvent writes one message as an SSE event named after its type, with the
+// resumable "<epoch>:<seq>" id for sequenced messages
+func writeEvent(w *bufio.Writer, epoch string, message []byte) error {
+	var header struct {
+		Type string `json:"type"`
+		Seq  uint64 `json:"seq"`
+	}
+	if err := json.Unmarshal(message, &header); err != nil {
+		log.Printf("Error reading event header: %v", err)
+		return nil
+	}
+
+	if header.Seq > 0 {
+		fmt.Fprintf(w, "id: %s:%d\n", epoch, header.Seq)
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", header.Type, message)
+	return w.Flush()
+}
@@ -0,0 +1,112 @@
+package websockets
+
+import (
+	"log"
+	"realtime-todos/helper"
+	"realtime-todos/initialisers"
+	"realtime-todos/models"
+	"time"
+
+	"gorm.io/gorm/clause"
+)
+
+// TicketTTL is how long a connect ticket stays valid after it is issued
+const TicketTTL = 30 * time.Second
+
+// ticket binds a one-time WebSocket connect ticket to a user and a room. A
+// RoomID of 0 is a ticket for the user channel.
+type ticket struct {
+	UserID    uint
+	Username  string
+	RoomID    uint
+	ExpiresAt time.Time
+	SessionID string
+}
+
+// TicketStore keeps issued connect tickets in the database until they are
+// used or expire, so a ticket issued by one instance can be redeemed on any
+// other behind the same load balancer
+type TicketStore struct{}
+
+var (
+	// Tickets is the global instance of TicketStore
+	Tickets = &TicketStore{}
+)
+
+// Issue mints a single-use ticket for the user to connect to the room from
+// the session
+func (s *TicketStore) Issue(userID uint, username string, sessionID string, roomID uint) (string, time.Time, error) {
+	value, err := helper.NewToken()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	expiresAt := time.Now().Add(TicketTTL)
+
+	s.removeExpired()
+	err = initialisers.DB.Create(&models.WebSocketTicket{
+		TokenHash: helper.HashToken(value),
+		UserID:    userID,
+		Username:  username,
+		RoomID:    roomID,
+		ExpiresAt: expiresAt,
+		SessionID: sessionID,
+	}).Error
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	return value, expiresAt, nil
+}
+
+// Consume removes the ticket and returns it if it is still valid for the room
+// and its session was not revoked. The delete is what claims the ticket, so
+// concurrent attempts to use it on different instances cannot both succeed.
+func (s *TicketStore) Consume(value string, roomID uint) (ticket, bool) {
+	var stored models.WebSocketTicket
+	result := initialisers.DB.Clauses(clause.Returning{}).
+		Where("token_hash = ?", helper.HashToken(value)).
+		Delete(&stored)
+	if result.Error != nil {
+		log.Println("Error consuming connect ticket:", result.Error)
+		return ticket{}, false
+	}
+	if result.RowsAffected == 0 {
+		return ticket{}, false
+	}
+
+	if time.Now().After(stored.ExpiresAt) || stored.RoomID != roomID {
+		return ticket{}, false
+	}
+
+	// The database has the final word, the revocation list of this instance
+	// may not know yet about a logout on another one
+	if stored.SessionID != "" {
+		var active int64
+		err := initialisers.DB.Model(&models.Session{}).
+			Where("token_id = ? AND revoked_at IS NULL", stored.SessionID).
+			Count(&active).Error
+		if err != nil {
+			log.Println("Error checking session of connect ticket:", err)
+			return ticket{}, false
+		}
+		if active == 0 {
+			return ticket{}, false
+		}
+	}
+
+	return ticket{
+		UserID:    stored.UserID,
+		Username:  stored.Username,
+		RoomID:    stored.RoomID,
+		ExpiresAt: stored.ExpiresAt,
+		SessionID: stored.SessionID,
+	}, true
+}
+
+// removeExpired drops stale tickets that were never used
+func (s *TicketStore) removeExpired() {
+	if err := initialisers.DB.Where("expires_at < ?", time.Now()).Delete(&models.WebSocketTicket{}).Error; err != nil {
+		log.Println("Error removing expired connect tickets:", err)
+	}
+}
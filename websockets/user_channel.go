@@ -0,0 +1,149 @@
+package websockets
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"realtime-todos/helper"
+	"realtime-todos/initialisers"
+	"realtime-todos/models"
+
+	"github.com/gofiber/contrib/websocket"
+	"github.com/gofiber/fiber/v2"
+)
+
+// The user channel at /ws/user delivers dashboard-level events about every
+// room the user belongs to, so the room list stays live without a socket per room.
+
+// AuthorizeUser runs before the /ws/user upgrade and identifies the user either
+// by a connect ticket from IssueUserWebSocketTicket or by CheckWebSocketAuth
+func (h *RoomHub) AuthorizeUser(c *fiber.Ctx) error {
+	var user models.User
+	sessionID, _ := c.Locals("sessionID").(string)
+	if value := c.Query("ticket"); value != "" {
+		t, ok := Tickets.Consume(value, 0)
+		if !ok {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Invalid or expired ticket",
+			})
+		}
+		user.ID = t.UserID
+		user.Username = t.Username
+		sessionID = t.SessionID
+	} else {
+		username, ok := helper.GetUsername(c)
+		if !ok {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Unauthorized",
+			})
+		}
+
+		if err := initialisers.DB.Where("username = ?", username).First(&user).Error; err != nil {
+			return helper.HandleError(c, err)
+		}
+	}
+
+	c.Locals("userID", user.ID)
+	c.Locals("username", user.Username)
+	c.Locals("sessionID", sessionID)
+	return c.Next()
+}
+
+func (h *RoomHub) HandleUserConnection(c *websocket.Conn) {
+	userID, _ := c.Locals("userID").(uint)
+	username, _ := c.Locals("username").(string)
+	sessionID, _ := c.Locals("sessionID").(string)
+
+	if userID == 0 || username == "" {
+		log.Printf("Unauthenticated user connection: username=%s", username)
+		c.Close()
+		return
+	}
+
+	h.mu.RLock()
+	conn := newConnection(c, 0, userID, username, h.config)
+	h.mu.RUnlock()
+	conn.SessionID = sessionID
+
+	h.addUserConnection(conn)
+	go conn.writePump()
+
+	defer func() {
+		h.removeUserConnection(conn)
+		conn.close(websocket.CloseNormalClosure, "")
+		<-conn.stopped
+	}()
+
+	// The user channel is receive-only, anything the client sends is ignored
+	conn.readPump(func([]byte) {})
+}
+
+func (h *RoomHub) addUserConnection(conn *Connection) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.users[conn.UserID] = append(h.users[conn.UserID], conn)
+	if h.shuttingDown {
+		conn.close(websocket.CloseServiceRestart, "server restarting")
+	}
+}
+
+func (h *RoomHub) removeUserConnection(conn *Connection) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	conns := h.users[conn.UserID]
+	for i, c := range conns {
+		if c == conn {
+			h.users[conn.UserID] = append(conns[:i], conns[i+1:]...)
+			break
+		}
+	}
+
+	if len(h.users[conn.UserID]) == 0 {
+		delete(h.users, conn.UserID)
+	}
+}
+
+// NotifyUser sends a message to every user channel the user has open, on every instance
+func (h *RoomHub) NotifyUser(userID uint, messageType string, payload interface{}) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		fmt.Printf("Error marshaling message: %v\n", err)
+		return
+	}
+
+	h.publish(Envelope{
+		Action:  ActionNotifyUser,
+		UserID:  userID,
+		Type:    messageType,
+		Payload: data,
+	})
+}
+
+// hasUserChannel reports whether the user has a user channel open on this instance
+func (h *RoomHub) hasUserChannel(userID uint) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return len(h.users[userID]) > 0
+}
+
+// sendToUser queues a message for this instance's user channels of the user
+func (h *RoomHub) sendToUser(userID uint, messageType string, payload interface{}) {
+	jsonMessage, err := json.Marshal(Message{Type: messageType, Payload: payload})
+	if err != nil {
+		fmt.Printf("Error marshaling message: %v\n", err)
+		return
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for _, conn := range h.users[userID] {
+		if !conn.enqueue(jsonMessage) {
+			fmt.Printf("Dropping slow consumer %s on user channel\n", conn.Username)
+			conn.close(CloseSlowConsumer, "send buffer full")
+		}
+	}
+}
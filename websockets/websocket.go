@@ -1,110 +1,423 @@
 package websockets
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"log"
+	"realtime-todos/helper"
 	"realtime-todos/initialisers"
 	"realtime-todos/models"
+	"strconv"
 	"sync"
+	"time"
 
 	"github.com/gofiber/contrib/websocket"
+	"github.com/gofiber/fiber/v2"
 )
 
 type RoomHub struct {
 	// connections stores active WebSocket connections per room
-	connections map[uint][]Connection
-	mu          sync.RWMutex
-}
-
-// Connection represents a WebSocket connection for a specific player
-type Connection struct {
-	Conn     *websocket.Conn
-	RoomId   uint
-	Username string
+	connections map[uint][]*Connection
+	// users stores active user channel connections per user
+	users map[uint][]*Connection
+	// logs numbers and keeps recent events per room for resuming clients
+	logs map[uint]*eventLog
+	// epoch identifies this instance's event logs, sequence numbers from
+	// another epoch cannot be resumed here. It changes on resync.
+	epoch string
+	// instance identifies this process in presence counts, unlike epoch it
+	// never changes
+	instance string
+	mu       sync.RWMutex
+	config   HubConfig
+	// pubsub carries broadcasts to every instance, including this one
+	pubsub PubSub
+	// presence tracks who is online per room across all instances
+	presence   map[uint]map[uint]*presenceEntry
+	presenceMu sync.Mutex
+	// localPresence counts this instance's sockets per room and user, as
+	// published to the others
+	localPresence    map[uint]map[uint]PresenceCount
+	localMu          sync.Mutex
+	presenceStop     chan struct{}
+	presenceStopOnce sync.Once
+	// commands are the client-to-server commands accepted on room sockets
+	commands commandRegistry
+	// shuttingDown turns away connections that arrive after Shutdown started
+	shuttingDown bool
 }
 
 // Message represents the structure of WebSocket messages
 type Message struct {
 	Type    string      `json:"type"`
 	Payload interface{} `json:"payload"`
+	// Seq orders the events of a room, it is only set for protocol v2 clients
+	Seq uint64 `json:"seq,omitempty"`
+}
+
+// ConnectedEvent is sent to protocol v2 clients right after they connect
+type ConnectedEvent struct {
+	Protocol int    `json:"protocol"`
+	Epoch    string `json:"epoch"`
+	Seq      uint64 `json:"seq"`
+}
+
+// ResyncRequiredEvent tells a resuming client that the events it missed are
+// no longer available and it has to refetch the room
+type ResyncRequiredEvent struct {
+	Epoch string `json:"epoch"`
+	Seq   uint64 `json:"seq"`
 }
 
+// Application close codes sent when the server ends a connection
+const (
+	CloseRemovedFromRoom = 4001
+	CloseSessionRevoked  = 4002
+	CloseShareRevoked    = 4003
+	CloseRoomDeleted     = 4004
+	CloseSlowConsumer    = 4008
+)
+
 var (
 	// Hub is the global instance of RoomHub
 	Hub = &RoomHub{
-		connections: make(map[uint][]Connection),
+		connections:   make(map[uint][]*Connection),
+		users:         make(map[uint][]*Connection),
+		logs:          make(map[uint]*eventLog),
+		presence:      make(map[uint]map[uint]*presenceEntry),
+		localPresence: make(map[uint]map[uint]PresenceCount),
+		presenceStop:  make(chan struct{}),
+		commands:      commandRegistry{handlers: make(map[string]CommandHandler)},
+		epoch:         newEpoch(),
+		instance:      newEpoch(),
+		config:        DefaultHubConfig(),
 	}
 )
 
-func (h *RoomHub) HandleConnection(c *websocket.Conn) {
-	// Get roomID and username from query params
+func init() {
+	Hub.UsePubSub(NewMemoryPubSub())
+}
 
-	roomID := c.Params("roomID")
-	username := c.Query("username")
+func newEpoch() string {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(buf)
+}
 
-	if roomID == "" || username == "" {
-		log.Printf("Missing required parameters: roomID=%s, username=%s", roomID, username)
-		c.Close()
-		return
+// UsePubSub switches the hub to a different broadcast backend and closes the
+// previous one
+func (h *RoomHub) UsePubSub(pubsub PubSub) error {
+	if err := pubsub.Subscribe(h.deliver); err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	previous := h.pubsub
+	h.pubsub = pubsub
+	h.mu.Unlock()
+
+	if previous != nil {
+		return previous.Close()
+	}
+	return nil
+}
+
+// publish hands an operation to the pub/sub backend, which delivers it back to
+// every instance through deliver
+func (h *RoomHub) publish(envelope Envelope) {
+	h.mu.RLock()
+	pubsub := h.pubsub
+	h.mu.RUnlock()
+
+	if err := pubsub.Publish(envelope); err != nil {
+		log.Printf("Error publishing %s for room %d: %v", envelope.Action, envelope.RoomID, err)
+	}
+}
+
+// deliver applies an operation published by any instance to the connections
+// held by this one
+func (h *RoomHub) deliver(envelope Envelope) {
+	switch envelope.Action {
+	case ActionBroadcast:
+		protocol := envelope.Protocol
+		var publicPayload interface{}
+		if envelope.PublicPayload != nil {
+			publicPayload = envelope.PublicPayload
+		}
+		h.broadcastEvent(envelope.RoomID, envelope.Type, envelope.Payload, publicPayload, protocol != ProtocolV1, func(conn *Connection) bool {
+			return protocol == 0 || conn.Protocol == protocol
+		})
+	case ActionLegacyRoom:
+		sendLegacyRoom(envelope.RoomID, envelope.Type)
+	case ActionDisconnectUser:
+		h.closeConnections(envelope.RoomID, CloseRemovedFromRoom, "removed from room", func(conn *Connection) bool {
+			return conn.UserID == envelope.UserID
+		})
+	case ActionCloseRoom:
+		h.closeRoom(envelope.RoomID)
+	case ActionClosePublic:
+		h.closeConnections(envelope.RoomID, CloseShareRevoked, "share link revoked", func(conn *Connection) bool {
+			return conn.Public
+		})
+	case ActionPresence:
+		h.applyPresence(envelope)
+	case ActionPresenceSync:
+		h.publishPresenceState()
+	case ActionEditingStarted:
+		h.applyEditing(envelope, true)
+	case ActionEditingStopped:
+		h.applyEditing(envelope, false)
+	case ActionNotifyUser:
+		h.sendToUser(envelope.UserID, envelope.Type, envelope.Payload)
+	case ActionAddedToRoom:
+		sendAddedToRoom(envelope.UserID, envelope.RoomID)
+	case ActionCloseSessions:
+		h.closeSessions(envelope.SessionIDs)
+	case ActionResync:
+		h.resync()
+		h.requestPresenceSync()
+	default:
+		log.Printf("Unknown room event action %q", envelope.Action)
+	}
+}
+
+// Configure sets the heartbeat and deadline settings for new connections
+func (h *RoomHub) Configure(config HubConfig) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.config = config
+}
+
+// AuthorizeRoom runs before the upgrade or event stream and rejects users who
+// are not members of the room they are trying to subscribe to. The user is
+// identified either by a connect ticket from IssueWebSocketTicket or by
+// CheckWebSocketAuth.
+func (h *RoomHub) AuthorizeRoom(c *fiber.Ctx) error {
+	roomID, err := c.ParamsInt("roomID")
+	if err != nil || roomID <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid room ID",
+		})
+	}
+
+	var user models.User
+	sessionID, _ := c.Locals("sessionID").(string)
+	if value := c.Query("ticket"); value != "" {
+		t, ok := Tickets.Consume(value, uint(roomID))
+		if !ok {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Invalid or expired ticket",
+			})
+		}
+		user.ID = t.UserID
+		user.Username = t.Username
+		sessionID = t.SessionID
+	} else {
+		username, ok := helper.GetUsername(c)
+		if !ok {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Unauthorized",
+			})
+		}
+
+		if err := initialisers.DB.Where("username = ?", username).First(&user).Error; err != nil {
+			return helper.HandleError(c, err)
+		}
 	}
 
-	// Convert roomID to uint
 	var room models.Room
-	if err := initialisers.DB.Where("id = ?", roomID).First(&room).Error; err != nil {
-		log.Printf("Error fetching room: %v\n", err)
+	if err := initialisers.DB.Preload("Users").Where("id = ?", roomID).First(&room).Error; err != nil {
+		return helper.HandleError(c, err)
+	}
+
+	if !helper.IsUserInRoom(user, room) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Forbidden",
+		})
+	}
+
+	c.Locals("userID", user.ID)
+	c.Locals("username", user.Username)
+	c.Locals("sessionID", sessionID)
+	c.Locals("roomID", room.ID)
+	return c.Next()
+}
+
+func (h *RoomHub) HandleConnection(c *websocket.Conn) {
+	// Identity and room membership are resolved by AuthorizeRoom before the upgrade
+	userID, _ := c.Locals("userID").(uint)
+	roomID, _ := c.Locals("roomID").(uint)
+	username, _ := c.Locals("username").(string)
+	public, _ := c.Locals("public").(bool)
+	sessionID, _ := c.Locals("sessionID").(string)
+
+	if roomID == 0 || (!public && (userID == 0 || username == "")) {
+		log.Printf("Unauthenticated connection: roomID=%d, username=%s", roomID, username)
 		c.Close()
 		return
 	}
 
+	// Clients opt into granular events with ?v=2, everyone else keeps
+	// receiving full room payloads. Public viewers always get v2 since full
+	// room payloads carry usernames.
+	protocol := ProtocolV1
+	if v, err := strconv.Atoi(c.Query("v")); (err == nil && v == ProtocolV2) || public {
+		protocol = ProtocolV2
+	}
+
 	// Create new connection
-	conn := Connection{
-		Conn:     c,
-		RoomId:   room.ID,
-		Username: username,
+	h.mu.RLock()
+	conn := newConnection(c, roomID, userID, username, h.config)
+	h.mu.RUnlock()
+	conn.Protocol = protocol
+	conn.Public = public
+	conn.SessionID = sessionID
+
+	// Resuming clients pass the epoch and last sequence number they saw as
+	// ?epoch=&since=
+	var since *uint64
+	if value := c.Query("since"); value != "" && protocol == ProtocolV2 {
+		if seq, err := strconv.ParseUint(value, 10, 64); err == nil {
+			since = &seq
+		}
 	}
 
 	// Add connection to hub
-	h.addConnection(conn)
+	h.attach(conn, c.Query("epoch"), since)
+	go conn.writePump()
 
-	// Remove connection when function returns
+	// Remove connection when function returns. The write pump has to finish
+	// before we return since the underlying *websocket.Conn is released then.
 	defer func() {
-		h.removeConnection(conn)
-		conn.Conn.Close()
+		h.detach(conn)
+		<-conn.stopped
 	}()
 
-	// Listen for WebSocket messages
-	for {
-		messageType, _, err := c.ReadMessage()
-		if err != nil {
-			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				log.Printf("Unexpected close error: %v", err)
+	// Run client commands until the peer goes away or stops answering pings.
+	// Public viewers are read-only, whatever they send is ignored.
+	conn.readPump(func(data []byte) {
+		if !conn.Public {
+			h.handleCommand(conn, data)
+		}
+	})
+}
+
+// attach registers a connection, whether a socket or an event stream, and
+// announces its presence
+func (h *RoomHub) attach(conn *Connection, epoch string, since *uint64) {
+	h.addConnection(conn, epoch, since)
+	if conn.Public {
+		return
+	}
+	h.sendPresenceSnapshot(conn)
+	h.publishPresence(conn, 1)
+}
+
+// detach undoes attach and asks the connection to shut down
+func (h *RoomHub) detach(conn *Connection) {
+	h.removeConnection(conn)
+	if !conn.Public {
+		h.releaseEditing(conn)
+		h.publishPresence(conn, -1)
+	}
+	conn.close(websocket.CloseNormalClosure, "")
+}
+
+// addConnection adds a new connection to the hub. Protocol v2 connections are
+// greeted with the current sequence number and, when since is set, the events
+// they missed. Both happen under the lock so no broadcast can slip in between.
+func (h *RoomHub) addConnection(conn *Connection, epoch string, since *uint64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if conn.Protocol == ProtocolV2 {
+		roomLog := h.roomLog(conn.RoomId)
+		greeting := Message{Type: "connected", Payload: ConnectedEvent{Protocol: conn.Protocol, Epoch: h.epoch, Seq: roomLog.seq}}
+
+		var replay []loggedEvent
+		if since != nil {
+			events, ok := roomLog.since(*since)
+			if ok && epoch == h.epoch {
+				replay = events
+			} else {
+				greeting = Message{Type: "resync_required", Payload: ResyncRequiredEvent{Epoch: h.epoch, Seq: roomLog.seq}}
 			}
-			break
 		}
-		if messageType == websocket.CloseMessage {
-			log.Printf("Received close message from %s in room %d", conn.Username, conn.RoomId)
-			break
+
+		// The write pump has not started yet, so the queue can still be
+		// grown to fit the replay on top of the usual buffer
+		conn.send = make(chan []byte, sendBufferSize+1+len(replay))
+		if data, err := json.Marshal(greeting); err == nil {
+			conn.send <- data
+		}
+		for _, event := range replay {
+			if conn.Public && !publicEvents[event.messageType] {
+				continue
+			}
+			conn.send <- event.dataFor(conn)
 		}
 	}
+
+	h.connections[conn.RoomId] = append(h.connections[conn.RoomId], conn)
+	if h.shuttingDown {
+		conn.close(websocket.CloseServiceRestart, "server restarting")
+	}
 }
 
-// addConnection adds a new connection to the hub
-func (h *RoomHub) addConnection(conn Connection) {
+// resync starts new event logs under a new epoch after the pub/sub backend
+// may have lost envelopes. The gap cannot be seen in the sequence numbers, so
+// protocol v2 clients are told to refetch their room instead of resuming.
+func (h *RoomHub) resync() {
 	h.mu.Lock()
 	defer h.mu.Unlock()
 
-	h.connections[conn.RoomId] = append(h.connections[conn.RoomId], conn)
+	h.epoch = newEpoch()
+	h.logs = make(map[uint]*eventLog)
+
+	data, err := json.Marshal(Message{Type: "resync_required", Payload: ResyncRequiredEvent{Epoch: h.epoch}})
+	if err != nil {
+		return
+	}
+	for _, conns := range h.connections {
+		for _, conn := range conns {
+			if conn.Protocol == ProtocolV2 && !conn.enqueue(data) {
+				conn.close(CloseSlowConsumer, "send buffer full")
+			}
+		}
+	}
+}
+
+// currentEpoch returns the epoch event IDs are issued under
+func (h *RoomHub) currentEpoch() string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return h.epoch
+}
+
+// roomLog returns the event log of a room, callers must hold h.mu for writing
+func (h *RoomHub) roomLog(roomId uint) *eventLog {
+	roomLog, ok := h.logs[roomId]
+	if !ok {
+		roomLog = &eventLog{}
+		h.logs[roomId] = roomLog
+	}
+	return roomLog
 }
 
 // removeConnection removes a connection from the hub
-func (h *RoomHub) removeConnection(conn Connection) {
+func (h *RoomHub) removeConnection(conn *Connection) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
 
 	conns := h.connections[conn.RoomId]
 	for i, c := range conns {
-		if c.Conn == conn.Conn {
+		if c == conn {
 			h.connections[conn.RoomId] = append(conns[:i], conns[i+1:]...)
 			break
 		}
@@ -116,46 +429,235 @@ func (h *RoomHub) removeConnection(conn Connection) {
 	}
 }
 
+// closeConnections closes every connection in a room matching the filter with
+// the given code. The read loop in HandleConnection then fails and removes the
+// connection from the hub.
+func (h *RoomHub) closeConnections(roomId uint, code int, reason string, match func(*Connection) bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for _, conn := range h.connections[roomId] {
+		if match(conn) {
+			conn.close(code, reason)
+		}
+	}
+}
+
+// DisconnectUser force-closes every connection a user has open in a room, on
+// every instance
+func (h *RoomHub) DisconnectUser(roomId uint, userID uint) {
+	h.publish(Envelope{Action: ActionDisconnectUser, RoomID: roomId, UserID: userID})
+}
+
+// CloseRoom force-closes every connection in a room on every instance
+func (h *RoomHub) CloseRoom(roomId uint) {
+	h.publish(Envelope{Action: ActionCloseRoom, RoomID: roomId})
+}
+
+// closeRoom closes this instance's connections to a room and forgets its events
+func (h *RoomHub) closeRoom(roomId uint) {
+	h.closeConnections(roomId, CloseRoomDeleted, "room deleted", func(*Connection) bool {
+		return true
+	})
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.logs, roomId)
+}
+
+// sessionBatchSize keeps a CloseSessions envelope well under the 8000 byte
+// limit of a Postgres NOTIFY payload
+const sessionBatchSize = 100
+
+// CloseSessions force-closes every room socket, event stream and user channel
+// opened with one of the sessions, on every instance
+func (h *RoomHub) CloseSessions(sessionIDs []string) {
+	for start := 0; start < len(sessionIDs); start += sessionBatchSize {
+		end := min(start+sessionBatchSize, len(sessionIDs))
+		h.publish(Envelope{Action: ActionCloseSessions, SessionIDs: sessionIDs[start:end]})
+	}
+}
+
+// closeSessions closes this instance's connections opened with one of the sessions
+func (h *RoomHub) closeSessions(sessionIDs []string) {
+	revoked := make(map[string]bool, len(sessionIDs))
+	for _, id := range sessionIDs {
+		revoked[id] = true
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for _, conns := range h.connections {
+		for _, conn := range conns {
+			if conn.SessionID != "" && revoked[conn.SessionID] {
+				conn.close(CloseSessionRevoked, "session revoked")
+			}
+		}
+	}
+	for _, conns := range h.users {
+		for _, conn := range conns {
+			if conn.SessionID != "" && revoked[conn.SessionID] {
+				conn.close(CloseSessionRevoked, "session revoked")
+			}
+		}
+	}
+}
+
 // BroadcastToRoom sends a message to all connected clients in a specific room
 func (h *RoomHub) BroadcastToRoom(roomId uint, messageType string, payload interface{}) {
+	h.BroadcastToProtocol(roomId, 0, messageType, payload)
+}
+
+// BroadcastToProtocol sends a message to the clients in a room that speak the
+// given protocol version, or to all of them when protocol is 0. Only messages
+// that reach protocol v2 clients are sequenced and logged.
+func (h *RoomHub) BroadcastToProtocol(roomId uint, protocol int, messageType string, payload interface{}) {
+	h.BroadcastWithPublic(roomId, protocol, messageType, payload, nil)
+}
+
+// BroadcastWithPublic is BroadcastToProtocol with a separate payload for the
+// room's public viewers, for events whose payload names members. A nil
+// publicPayload sends them payload as is.
+func (h *RoomHub) BroadcastWithPublic(roomId uint, protocol int, messageType string, payload interface{}, publicPayload interface{}) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		fmt.Printf("Error marshaling message: %v\n", err)
+		return
+	}
+
+	envelope := Envelope{
+		Action:   ActionBroadcast,
+		RoomID:   roomId,
+		Protocol: protocol,
+		Type:     messageType,
+		Payload:  data,
+	}
+
+	if publicPayload != nil {
+		envelope.PublicPayload, err = json.Marshal(publicPayload)
+		if err != nil {
+			fmt.Printf("Error marshaling message: %v\n", err)
+			return
+		}
+	}
+
+	h.publish(envelope)
+}
+
+// hasProtocol reports whether any client in the room speaks the given protocol version
+func (h *RoomHub) hasProtocol(roomId uint, protocol int) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for _, conn := range h.connections[roomId] {
+		if conn.Protocol == protocol {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *RoomHub) broadcast(roomId uint, messageType string, payload interface{}, sequenced bool, match func(*Connection) bool) {
+	h.broadcastEvent(roomId, messageType, payload, nil, sequenced, match)
+}
+
+// broadcastEvent is broadcast with a separate payload for public viewers,
+// sent under the same sequence number
+func (h *RoomHub) broadcastEvent(roomId uint, messageType string, payload interface{}, publicPayload interface{}, sequenced bool, match func(*Connection) bool) {
 	message := Message{
 		Type:    messageType,
 		Payload: payload,
 	}
 
+	// Public viewers only ever see the todo list itself
+	visible := match
+	match = func(conn *Connection) bool {
+		return visible(conn) && (!conn.Public || publicEvents[messageType])
+	}
+
+	// Sequenced messages take the write lock so numbering, logging and
+	// queueing happen in the same order for every client
+	if sequenced {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+
+		roomLog := h.roomLog(roomId)
+		message.Seq = roomLog.next()
+		jsonMessage, err := json.Marshal(message)
+		if err != nil {
+			fmt.Printf("Error marshaling message: %v\n", err)
+			return
+		}
+		publicMessage, err := h.publicMessage(message, publicPayload)
+		if err != nil {
+			fmt.Printf("Error marshaling message: %v\n", err)
+			return
+		}
+		roomLog.append(message.Seq, messageType, jsonMessage, publicMessage)
+		h.sendEvent(roomId, jsonMessage, publicMessage, match)
+		return
+	}
+
 	jsonMessage, err := json.Marshal(message)
 	if err != nil {
 		fmt.Printf("Error marshaling message: %v\n", err)
 		return
 	}
+	publicMessage, err := h.publicMessage(message, publicPayload)
+	if err != nil {
+		fmt.Printf("Error marshaling message: %v\n", err)
+		return
+	}
 
 	h.mu.RLock()
 	defer h.mu.RUnlock()
 
-	for _, conn := range h.connections[roomId] {
-		err := conn.Conn.WriteMessage(websocket.TextMessage, jsonMessage)
-		if err != nil {
-			fmt.Printf("Error sending message to %s: %v\n", conn.Username, err)
-		}
-	}
+	h.sendEvent(roomId, jsonMessage, publicMessage, match)
 }
 
-func BroadcastUserJoined(room models.Room) {
-	Hub.BroadcastToRoom(room.ID, "user_joined", room)
+// publicMessage encodes the message with the public payload, or returns nil
+// when there is none
+func (h *RoomHub) publicMessage(message Message, publicPayload interface{}) ([]byte, error) {
+	if publicPayload == nil {
+		return nil, nil
+	}
+	message.Payload = publicPayload
+	return json.Marshal(message)
 }
 
-func BroadcastUserLeft(room models.Room) {
-	Hub.BroadcastToRoom(room.ID, "user_left", room)
-}
+// sendEvent is send with publicMessage, when set, going to public viewers.
+// Callers must hold h.mu.
+func (h *RoomHub) sendEvent(roomId uint, jsonMessage []byte, publicMessage []byte, match func(*Connection) bool) {
+	if publicMessage == nil {
+		h.send(roomId, jsonMessage, match)
+		return
+	}
 
-func BroadcastTodosUpdated(room models.Room) {
-	Hub.BroadcastToRoom(room.ID, "todos_updated", room)
+	h.send(roomId, jsonMessage, func(conn *Connection) bool {
+		return !conn.Public && match(conn)
+	})
+	h.send(roomId, publicMessage, func(conn *Connection) bool {
+		return conn.Public && match(conn)
+	})
 }
 
-func BroadcastRoomNameUpdated(room models.Room) {
-	Hub.BroadcastToRoom(room.ID, "room_name_updated", room)
+// send queues the message for each matching connection's write pump, dropping
+// any client that has fallen so far behind that its buffer is full. Callers
+// must hold h.mu.
+func (h *RoomHub) send(roomId uint, jsonMessage []byte, match func(*Connection) bool) {
+	for _, conn := range h.connections[roomId] {
+		if !match(conn) {
+			continue
+		}
+		if !conn.enqueue(jsonMessage) {
+			fmt.Printf("Dropping slow consumer %s in room %d\n", conn.Username, roomId)
+			conn.close(CloseSlowConsumer, "send buffer full")
+		}
+	}
 }
 
-func BroadcastRoomDeleted(room models.Room) {
-	Hub.BroadcastToRoom(room.ID, "room_deleted", room)
+func DisconnectUser(room models.Room, user models.User) {
+	Hub.DisconnectUser(room.ID, user.ID)
 }